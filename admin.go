@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// adminAuthorized reports whether the request carries the configured admin
+// token as "Authorization: Bearer <token>". Without a configured token no
+// request is authorized.
+func adminAuthorized(c *gin.Context) bool {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	want := config.Server.AdminToken
+	return ok && want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1
+}
+
+// requireAdmin guards the admin endpoints, which can replace the YouTube
+// cookies, purge the render cache and show the configuration.
+func requireAdmin(c *gin.Context) {
+	if !adminAuthorized(c) {
+		c.Header("WWW-Authenticate", `Bearer realm="drummer admin"`)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "The admin token is missing or wrong"})
+		return
+	}
+	c.Next()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testAdminToken = "test-admin-token"
+
+// authorizeAdmin configures the admin token for the rest of the test and
+// sends it with req.
+func authorizeAdmin(t *testing.T, req *http.Request) *http.Request {
+	t.Helper()
+
+	if config.Server.AdminToken != testAdminToken {
+		old := config
+		cfg := *config
+		cfg.Server.AdminToken = testAdminToken
+		config = &cfg
+		t.Cleanup(func() { config = old })
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	return req
+}
+
+func TestAdminEndpointsRequireToken(t *testing.T) {
+	router := setupRouter()
+
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong-token", http.StatusUnauthorized},
+		{testAdminToken, http.StatusUnauthorized},
+		{"Bearer " + testAdminToken, http.StatusOK},
+	}
+
+	// Without a configured token the endpoints are closed to everyone
+	req, _ := http.NewRequest("GET", "/api/admin/config", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d without a configured token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	authorizeAdmin(t, req)
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/api/admin/config", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Errorf("%q: Expected status code %d, got %d", test.header, test.status, w.Code)
+		}
+	}
+}
+
+func TestDownloadWithUserCookiesRequiresToken(t *testing.T) {
+	router := setupRouter()
+
+	for _, path := range []string{"/api/youtube", "/api/youtube/playlist"} {
+		body, _ := json.Marshal(map[string]string{"url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "user": "alex"})
+		req, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: Expected status code %d, got %d", path, http.StatusUnauthorized, w.Code)
+		}
+	}
+}
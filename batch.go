@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Defaults for grouping queued songs into one Spleeter run; override them
+// with separation.batch_size and separation.batch_max_duration.
+const (
+	defaultBatchSize        = 4
+	defaultBatchMaxDuration = 30 * time.Minute
+)
+
+// separationRequest is one song waiting to be separated.
+type separationRequest struct {
+	ctx       context.Context
+	input     string
+	outputDir string
+	duration  time.Duration
+	done      chan error
+}
+
+// separationBatcher serializes Spleeter runs and groups the songs that queue
+// up behind a running one into a single invocation, so that the model is
+// loaded once per batch. Stems are moved back to each song's own output
+// directory afterwards.
+type separationBatcher struct {
+	maxSize     int
+	maxDuration time.Duration
+	separate    func(ctx context.Context, inputs []string, outputDir string, limit time.Duration) error
+
+	requests chan *separationRequest
+	once     sync.Once
+}
+
+var separations = newSeparationBatcher(defaultBatchSize, defaultBatchMaxDuration, separateFiles)
+
+func newSeparationBatcher(maxSize int, maxDuration time.Duration, separate func(context.Context, []string, string, time.Duration) error) *separationBatcher {
+	return &separationBatcher{
+		maxSize:     maxSize,
+		maxDuration: maxDuration,
+		separate:    separate,
+		requests:    make(chan *separationRequest),
+	}
+}
+
+// loadBatchLimits applies the configured batch limits.
+func loadBatchLimits() {
+	separations.maxSize = config.Separation.BatchSize
+	separations.maxDuration = config.Separation.BatchMaxDuration
+}
+
+// separateStems splits inputPath into stems below outputDir, in the same
+// layout as the spleeter CLI. duration is the length of the song, used for
+// batching and time limits. It blocks until the song's batch has run.
+func separateStems(ctx context.Context, inputPath, outputDir string, duration time.Duration) error {
+	b := separations
+	b.once.Do(func() { go b.run() })
+
+	req := &separationRequest{
+		ctx:       ctx,
+		input:     inputPath,
+		outputDir: outputDir,
+		duration:  duration,
+		done:      make(chan error, 1),
+	}
+
+	select {
+	case b.requests <- req:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return <-req.done
+}
+
+func (b *separationBatcher) run() {
+	var pending []*separationRequest
+	for {
+		if len(pending) == 0 {
+			pending = append(pending, <-b.requests)
+		}
+
+		// Pick up everything else that queued while the last batch ran
+	drain:
+		for {
+			select {
+			case req := <-b.requests:
+				pending = append(pending, req)
+			default:
+				break drain
+			}
+		}
+
+		var batch []*separationRequest
+		batch, pending = b.takeBatch(pending)
+		if len(batch) > 0 {
+			b.runBatch(batch)
+		}
+	}
+}
+
+// takeBatch picks requests in arrival order up to the size and duration
+// limits, dropping any that were cancelled while they waited. A single song
+// longer than the duration limit is still taken, on its own. Inputs with the
+// same name would write their stems to the same directory, so they go in
+// separate batches.
+func (b *separationBatcher) takeBatch(pending []*separationRequest) (batch, rest []*separationRequest) {
+	var total time.Duration
+	names := make(map[string]bool)
+	for _, req := range pending {
+		if req.ctx.Err() != nil {
+			req.done <- req.ctx.Err()
+			continue
+		}
+		name := stemsDirName(req.input)
+		if len(batch) > 0 && (len(batch) >= b.maxSize || total+req.duration > b.maxDuration || names[name]) {
+			rest = append(rest, req)
+			continue
+		}
+		batch = append(batch, req)
+		names[name] = true
+		total += req.duration
+	}
+	return batch, rest
+}
+
+func (b *separationBatcher) runBatch(batch []*separationRequest) {
+	for _, req := range batch {
+		setStage(req.ctx, StageSeparating)
+	}
+
+	if len(batch) == 1 {
+		req := batch[0]
+		req.done <- b.separate(req.ctx, []string{req.input}, req.outputDir, stageLimits[StageSeparating].forDuration(req.duration))
+		return
+	}
+
+	// The batch is only cancelled once every song in it has been
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	remaining := int32(len(batch))
+
+	var inputs []string
+	var total time.Duration
+	for _, req := range batch {
+		stop := context.AfterFunc(req.ctx, func() {
+			if atomic.AddInt32(&remaining, -1) == 0 {
+				cancel()
+			}
+		})
+		defer stop()
+
+		inputs = append(inputs, req.input)
+		total += req.duration
+	}
+
+	batchDir := filepath.Join(config.Paths.Temp, "batch-"+uuid.New().String())
+	defer os.RemoveAll(batchDir)
+
+	log.Printf("Separating %d songs (%v of audio) in one batch", len(batch), total)
+	err := b.separate(ctx, inputs, batchDir, stageLimits[StageSeparating].forDuration(total))
+	if isTimeout(err) {
+		// Separating the songs again one by one would take as long, on top
+		// of the time already spent
+		for _, req := range batch {
+			req.done <- err
+		}
+		return
+	}
+	if err != nil && ctx.Err() == nil {
+		// Don't let one bad song fail the others: separate them one by one
+		log.Printf("Batch separation failed, separating songs individually: %v", err)
+		for _, req := range batch {
+			if req.ctx.Err() != nil {
+				req.done <- req.ctx.Err()
+				continue
+			}
+			req.done <- b.separate(req.ctx, []string{req.input}, req.outputDir, stageLimits[StageSeparating].forDuration(req.duration))
+		}
+		return
+	}
+
+	for _, req := range batch {
+		if req.ctx.Err() != nil {
+			req.done <- req.ctx.Err()
+			continue
+		}
+		req.done <- moveStems(batchDir, req.outputDir, req.input)
+	}
+}
+
+// moveStems moves the stems Spleeter wrote for input from one output
+// directory to another.
+func moveStems(fromDir, toDir, input string) error {
+	name := stemsDirName(input)
+	if err := os.MkdirAll(toDir, 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(fromDir, name), filepath.Join(toDir, name))
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSeparate writes a stem for each input and records the batches it was
+// given. Inputs containing "bad" fail whenever they are part of a run.
+type fakeSeparate struct {
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (f *fakeSeparate) separate(ctx context.Context, inputs []string, outputDir string, limit time.Duration) error {
+	f.mu.Lock()
+	f.batches = append(f.batches, inputs)
+	f.mu.Unlock()
+
+	for _, input := range inputs {
+		if strings.Contains(input, "bad") {
+			return &stageError{Stage: StageSeparating, Output: "Invalid data found when processing input"}
+		}
+	}
+	for _, input := range inputs {
+		dir := filepath.Join(outputDir, strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)))
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "vocals.wav"), []byte(input), 0644)
+	}
+	return nil
+}
+
+func newTestRequest(t *testing.T, ctx context.Context, input string, duration time.Duration) *separationRequest {
+	return &separationRequest{
+		ctx:       ctx,
+		input:     input,
+		outputDir: t.TempDir(),
+		duration:  duration,
+		done:      make(chan error, 1),
+	}
+}
+
+func TestTakeBatch(t *testing.T) {
+	useTestDataDirs(t)
+	b := newSeparationBatcher(3, 10*time.Minute, nil)
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	pending := []*separationRequest{
+		newTestRequest(t, context.Background(), "a.mp3", 4*time.Minute),
+		newTestRequest(t, cancelled, "b.mp3", time.Minute),
+		newTestRequest(t, context.Background(), "c.mp3", 8*time.Minute), // over the duration limit
+		newTestRequest(t, context.Background(), "d.mp3", 5*time.Minute),
+		newTestRequest(t, context.Background(), "e.mp3", time.Minute), // over the size limit
+	}
+
+	batch, rest := b.takeBatch(pending)
+
+	if len(batch) != 3 || batch[0].input != "a.mp3" || batch[1].input != "d.mp3" || batch[2].input != "e.mp3" {
+		t.Errorf("Expected a, d and e to be batched, got %d songs", len(batch))
+	}
+	if len(rest) != 1 || rest[0].input != "c.mp3" {
+		t.Errorf("Expected c to wait for the next batch")
+	}
+	if err := <-pending[1].done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled request to be answered with its error, got %v", err)
+	}
+
+	// A single long song is still taken on its own
+	batch, _ = b.takeBatch(rest)
+	if len(batch) != 1 {
+		t.Errorf("Expected a song over the duration limit to run alone")
+	}
+}
+
+func TestTakeBatchSeparatesSameNames(t *testing.T) {
+	b := newSeparationBatcher(4, time.Hour, nil)
+
+	pending := []*separationRequest{
+		newTestRequest(t, context.Background(), "first/segment-000.wav", time.Minute),
+		newTestRequest(t, context.Background(), "second/segment-000.wav", time.Minute),
+		newTestRequest(t, context.Background(), "second/segment-001.wav", time.Minute),
+	}
+
+	batch, rest := b.takeBatch(pending)
+	if len(batch) != 2 || batch[1].input != "second/segment-001.wav" {
+		t.Errorf("Expected the second segment-000 to be left out of the batch, got %d songs", len(batch))
+	}
+	if len(rest) != 1 || rest[0].input != "second/segment-000.wav" {
+		t.Errorf("Expected the second segment-000 to wait for the next batch")
+	}
+}
+
+func TestRunBatchSplitsOutputs(t *testing.T) {
+	useTestDataDirs(t)
+	fake := &fakeSeparate{}
+	b := newSeparationBatcher(4, time.Hour, fake.separate)
+
+	batch := []*separationRequest{
+		newTestRequest(t, context.Background(), "uploads/first.mp3", time.Minute),
+		newTestRequest(t, context.Background(), "uploads/second.mp3", time.Minute),
+	}
+	b.runBatch(batch)
+
+	if len(fake.batches) != 1 || len(fake.batches[0]) != 2 {
+		t.Fatalf("Expected one run with both songs, got %v", fake.batches)
+	}
+	for _, req := range batch {
+		if err := <-req.done; err != nil {
+			t.Errorf("%s: unexpected error %v", req.input, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(req.input), ".mp3")
+		content, err := os.ReadFile(filepath.Join(req.outputDir, name, "vocals.wav"))
+		if err != nil || string(content) != req.input {
+			t.Errorf("%s: expected its own stems in its output directory", req.input)
+		}
+	}
+}
+
+func TestRunBatchFallsBackToSingleSongs(t *testing.T) {
+	fake := &fakeSeparate{}
+	b := newSeparationBatcher(4, time.Hour, fake.separate)
+
+	good := newTestRequest(t, context.Background(), "uploads/good.mp3", time.Minute)
+	bad := newTestRequest(t, context.Background(), "uploads/bad.mp3", time.Minute)
+	b.runBatch([]*separationRequest{good, bad})
+
+	if err := <-good.done; err != nil {
+		t.Errorf("Expected the good song to succeed on its own, got %v", err)
+	}
+	if err := <-bad.done; err == nil {
+		t.Error("Expected the bad song to fail")
+	}
+	if len(fake.batches) != 3 {
+		t.Errorf("Expected one batch run and two single runs, got %v", fake.batches)
+	}
+}
+
+func TestRunBatchTimeoutFailsEverySong(t *testing.T) {
+	var runs int
+	b := newSeparationBatcher(4, time.Hour, func(ctx context.Context, inputs []string, outputDir string, limit time.Duration) error {
+		runs++
+		return &stageError{Stage: StageSeparating, TimedOut: true, Limit: limit}
+	})
+
+	batch := []*separationRequest{
+		newTestRequest(t, context.Background(), "uploads/first.mp3", time.Minute),
+		newTestRequest(t, context.Background(), "uploads/second.mp3", time.Minute),
+	}
+	b.runBatch(batch)
+
+	for _, req := range batch {
+		if err := <-req.done; !isTimeout(err) {
+			t.Errorf("%s: Expected a stage timeout, got %v", req.input, err)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("Expected the songs not to be separated again one by one, got %d runs", runs)
+	}
+}
+
+func TestSeparateStems(t *testing.T) {
+	fake := &fakeSeparate{}
+	saved := separations
+	separations = newSeparationBatcher(4, time.Hour, fake.separate)
+	defer func() { separations = saved }()
+
+	outputDir := t.TempDir()
+	err := separateStems(context.Background(), "uploads/song.mp3", outputDir, 3*time.Minute)
+	if err != nil {
+		t.Fatalf("separateStems failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "song", "vocals.wav")); err != nil {
+		t.Errorf("Expected stems in the output directory: %v", err)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Default size budget of the render cache; override it with cache.size.
+const defaultRenderCacheSize = 5 << 30
+
+// Default directory of the render cache
+var defaultRenderCacheDir = filepath.Join("cache", "renders")
+
+// renderParams describes how a render was produced. Together with the hash
+// of the input audio it identifies a cached render.
+type renderParams struct {
+	Backend string `json:"backend"`
+	Model   string `json:"model"`
+	Recipe  string `json:"recipe"`
+	Format  string `json:"format"`
+}
+
+// The render removeDrums produces
+var drumlessRender = renderParams{
+	Backend: "spleeter",
+	Model:   defaultSpleeterModel,
+	Recipe:  strings.Join(drumlessStems, "+"),
+	Format:  "mp3-vbr0-44100-stereo",
+}
+
+func (p renderParams) key(audioHash string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{audioHash, p.Backend, p.Model, p.Recipe, p.Format}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// CacheEntry is one cached render.
+type CacheEntry struct {
+	Key       string `json:"key"`
+	AudioHash string `json:"audio_hash"`
+	renderParams
+	Size       int64     `json:"size"`
+	Hits       int       `json:"hits"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// renderCache is a content-addressed store of finished renders below dir,
+// indexed in the render_cache table of db. When the files add up to more
+// than budget bytes the least recently used renders are evicted.
+type renderCache struct {
+	db     *database
+	dir    string
+	budget int64
+
+	mu sync.Mutex
+}
+
+// newRenderCache returns a cache in the configured directory and of the
+// configured size, indexed in db. A size of 0 turns the cache off.
+func newRenderCache(db *database) *renderCache {
+	return &renderCache{db: db, dir: config.Cache.Dir, budget: int64(config.Cache.Size)}
+}
+
+// loadRenderCache applies the configured model and encoding, by which
+// renders are keyed.
+func loadRenderCache() {
+	drumlessRender.Model = config.Separation.Model
+	drumlessRender.Format = config.Encoding.format()
+}
+
+// parseSize parses a byte count with an optional KB, MB or GB suffix (powers
+// of 1024).
+func parseSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		scale  int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+
+	value = strings.ToUpper(strings.TrimSpace(value))
+	scale := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			scale = unit.scale
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * scale, nil
+}
+
+func (c *renderCache) enabled() bool {
+	return c.budget > 0
+}
+
+func (c *renderCache) path(key string) string {
+	return filepath.Join(c.dir, key+".mp3")
+}
+
+const cacheColumns = `key, audio_hash, backend, model, recipe, format, size, hits, created_at, last_used_at`
+
+func scanCacheEntry(row interface{ Scan(...any) error }) (*CacheEntry, error) {
+	var e CacheEntry
+	err := row.Scan(&e.Key, &e.AudioHash, &e.Backend, &e.Model, &e.Recipe, &e.Format, &e.Size, &e.Hits, &e.CreatedAt, &e.LastUsedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// fetch copies the render stored under key to outputPath, reporting whether
+// there was one.
+func (c *renderCache) fetch(key, outputPath string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := scanCacheEntry(c.db.QueryRow(c.db.rebind(`SELECT `+cacheColumns+` FROM render_cache WHERE key = ?`), key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = copyFile(c.path(key), outputPath)
+	if errors.Is(err, os.ErrNotExist) {
+		// The file was removed behind our back
+		_, err = c.db.Exec(c.db.rebind(`DELETE FROM render_cache WHERE key = ?`), key)
+		return false, err
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return false, err
+	}
+
+	_, err = c.db.Exec(c.db.rebind(`UPDATE render_cache SET hits = hits + 1, last_used_at = ? WHERE key = ?`), time.Now(), key)
+	return true, err
+}
+
+// store adds a copy of the render at path to the cache and evicts old
+// renders to stay within the budget.
+func (c *renderCache) store(key, audioHash string, params renderParams, path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tmp := c.path(key) + ".tmp"
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	info, err := os.Stat(tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, c.path(key)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	now := time.Now()
+	_, err = c.db.Exec(c.db.rebind(`INSERT INTO render_cache (`+cacheColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET audio_hash = excluded.audio_hash, backend = excluded.backend,
+			model = excluded.model, recipe = excluded.recipe, format = excluded.format, size = excluded.size,
+			hits = 0, created_at = excluded.created_at, last_used_at = excluded.last_used_at`),
+		key, audioHash, params.Backend, params.Model, params.Recipe, params.Format, info.Size(), now, now)
+	if err != nil {
+		os.Remove(c.path(key))
+		return err
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used renders until the cache fits its
+// budget. The caller must hold c.mu.
+func (c *renderCache) evict() error {
+	var total int64
+	if err := c.db.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM render_cache`).Scan(&total); err != nil {
+		return err
+	}
+
+	for total > c.budget {
+		var key string
+		var size int64
+		err := c.db.QueryRow(`SELECT key, size FROM render_cache ORDER BY last_used_at LIMIT 1`).Scan(&key, &size)
+		if err != nil {
+			return err
+		}
+		if err := c.removeEntry(key); err != nil {
+			return err
+		}
+		log.Printf("Evicted render %s (%d bytes) from the cache", key, size)
+		total -= size
+	}
+	return nil
+}
+
+// removeEntry deletes one render. The caller must hold c.mu.
+func (c *renderCache) removeEntry(key string) error {
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	_, err := c.db.Exec(c.db.rebind(`DELETE FROM render_cache WHERE key = ?`), key)
+	return err
+}
+
+func (c *renderCache) entries() ([]*CacheEntry, error) {
+	rows, err := c.db.Query(`SELECT ` + cacheColumns + ` FROM render_cache ORDER BY last_used_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*CacheEntry
+	for rows.Next() {
+		e, err := scanCacheEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// purge removes every render, returning how many there were.
+func (c *renderCache) purge() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range entries {
+		if err := c.removeEntry(e.Key); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// renderDrumless writes a drumless mix of inputPath to outputPath, reusing a
+// cached render of the same audio when there is one. Cache failures are
+// logged and never fail the job.
+func (c *renderCache) renderDrumless(ctx context.Context, inputPath, outputPath, workDir string) error {
+	if !c.enabled() {
+		return removeDrums(ctx, inputPath, outputPath, workDir)
+	}
+
+	audioHash, err := hashFile(inputPath)
+	if err != nil {
+		log.Printf("Failed to hash %s, skipping the render cache: %v", inputPath, err)
+		return removeDrums(ctx, inputPath, outputPath, workDir)
+	}
+	key := drumlessRender.key(audioHash)
+
+	hit, err := c.fetch(key, outputPath)
+	if err != nil {
+		log.Printf("Failed to read render %s from the cache: %v", key, err)
+	}
+	if hit {
+		log.Printf("Using cached render %s for %s", key, inputPath)
+		return nil
+	}
+
+	if err := removeDrums(ctx, inputPath, outputPath, workDir); err != nil {
+		return err
+	}
+	if err := c.store(key, audioHash, drumlessRender, outputPath); err != nil {
+		log.Printf("Failed to add render %s to the cache: %v", key, err)
+	}
+	return nil
+}
+
+func (s *server) getRenderCache(c *gin.Context) {
+	entries, err := s.jobs.renders.entries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read render cache"})
+		return
+	}
+
+	var size int64
+	for _, e := range entries {
+		size += e.Size
+	}
+	if entries == nil {
+		entries = make([]*CacheEntry, 0)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"entries": entries,
+		"count":   len(entries),
+		"size":    size,
+		"budget":  s.jobs.renders.budget,
+	})
+}
+
+func (s *server) purgeRenderCache(c *gin.Context) {
+	removed, err := s.jobs.renders.purge()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge render cache"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": removed})
+}
+
+func (s *server) deleteRenderCacheEntry(c *gin.Context) {
+	key := c.Param("key")
+	renders := s.jobs.renders
+
+	renders.mu.Lock()
+	defer renders.mu.Unlock()
+
+	var exists bool
+	err := renders.db.QueryRow(renders.db.rebind(`SELECT EXISTS(SELECT 1 FROM render_cache WHERE key = ?)`), key).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read render cache"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cache entry not found"})
+		return
+	}
+
+	if err := renders.removeEntry(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove cache entry"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"removed": 1})
+}
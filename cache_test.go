@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestRenderCache points the render cache of s at a temporary directory.
+func useTestRenderCache(t *testing.T, s *server, budget int64) *renderCache {
+	t.Helper()
+
+	s.jobs.renders = &renderCache{db: s.db, dir: t.TempDir(), budget: budget}
+	return s.jobs.renders
+}
+
+func writeRender(t *testing.T, dir, name string, size int) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRenderCacheStoreAndFetch(t *testing.T) {
+	srv := setupTestDB(t)
+	cache := useTestRenderCache(t, srv, 1<<20)
+	dir := t.TempDir()
+
+	key := drumlessRender.key("abc")
+	if err := cache.store(key, "abc", drumlessRender, writeRender(t, dir, "render.mp3", 100)); err != nil {
+		t.Fatalf("Failed to store render: %v", err)
+	}
+
+	output := filepath.Join(dir, "output.mp3")
+	hit, err := cache.fetch(key, output)
+	if err != nil || !hit {
+		t.Fatalf("Expected a cache hit, got %v, %v", hit, err)
+	}
+	if info, err := os.Stat(output); err != nil || info.Size() != 100 {
+		t.Errorf("Expected the cached render to be copied to the output, got %v", err)
+	}
+
+	// Different parameters are a different render
+	other := drumlessRender
+	other.Format = "wav"
+	if other.key("abc") == key {
+		t.Error("Expected the output format to change the cache key")
+	}
+	hit, err = cache.fetch(other.key("abc"), filepath.Join(dir, "other.mp3"))
+	if err != nil || hit {
+		t.Errorf("Expected a cache miss, got %v, %v", hit, err)
+	}
+
+	entries, _ := cache.entries()
+	if len(entries) != 1 || entries[0].Hits != 1 {
+		t.Errorf("Expected one entry with one hit, got %+v", entries)
+	}
+}
+
+func TestRenderCacheMissingFile(t *testing.T) {
+	srv := setupTestDB(t)
+	cache := useTestRenderCache(t, srv, 1<<20)
+	dir := t.TempDir()
+
+	key := drumlessRender.key("gone")
+	cache.store(key, "gone", drumlessRender, writeRender(t, dir, "render.mp3", 10))
+	os.Remove(cache.path(key))
+
+	hit, err := cache.fetch(key, filepath.Join(dir, "output.mp3"))
+	if err != nil || hit {
+		t.Errorf("Expected a cache miss for a missing file, got %v, %v", hit, err)
+	}
+	if entries, _ := cache.entries(); len(entries) != 0 {
+		t.Errorf("Expected the stale entry to be removed, got %d entries", len(entries))
+	}
+}
+
+func TestRenderCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	srv := setupTestDB(t)
+	cache := useTestRenderCache(t, srv, 250)
+	dir := t.TempDir()
+
+	keys := []string{drumlessRender.key("a"), drumlessRender.key("b"), drumlessRender.key("c")}
+	cache.store(keys[0], "a", drumlessRender, writeRender(t, dir, "a.mp3", 100))
+	time.Sleep(10 * time.Millisecond)
+	cache.store(keys[1], "b", drumlessRender, writeRender(t, dir, "b.mp3", 100))
+	time.Sleep(10 * time.Millisecond)
+
+	// Using the first render makes the second the least recently used
+	if hit, _ := cache.fetch(keys[0], filepath.Join(dir, "out.mp3")); !hit {
+		t.Fatal("Expected a cache hit")
+	}
+	time.Sleep(10 * time.Millisecond)
+	cache.store(keys[2], "c", drumlessRender, writeRender(t, dir, "c.mp3", 100))
+
+	entries, _ := cache.entries()
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries within the budget, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Key == keys[1] {
+			t.Error("Expected the least recently used render to be evicted")
+		}
+	}
+	if _, err := os.Stat(cache.path(keys[1])); !os.IsNotExist(err) {
+		t.Error("Expected the evicted render's file to be removed")
+	}
+}
+
+func TestRenderCacheAdminEndpoints(t *testing.T) {
+	srv := setupTestDB(t)
+	cache := useTestRenderCache(t, srv, 1<<20)
+	dir := t.TempDir()
+
+	keys := []string{drumlessRender.key("a"), drumlessRender.key("b")}
+	cache.store(keys[0], "a", drumlessRender, writeRender(t, dir, "a.mp3", 100))
+	cache.store(keys[1], "b", drumlessRender, writeRender(t, dir, "b.mp3", 50))
+
+	router := setupServerRouter(srv)
+
+	req, _ := http.NewRequest("GET", "/api/admin/cache", nil)
+	authorizeAdmin(t, req)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	var stats struct {
+		Entries []CacheEntry `json:"entries"`
+		Count   int          `json:"count"`
+		Size    int64        `json:"size"`
+		Budget  int64        `json:"budget"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &stats)
+	if stats.Count != 2 || stats.Size != 150 || stats.Budget != 1<<20 {
+		t.Errorf("Unexpected cache stats: %+v", stats)
+	}
+	if stats.Entries[0].Backend != "spleeter" || stats.Entries[0].Model != defaultSpleeterModel {
+		t.Errorf("Expected render parameters in the entries, got %+v", stats.Entries[0])
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/admin/cache/"+keys[0], nil)
+	authorizeAdmin(t, req)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/admin/cache/"+keys[0], nil)
+	authorizeAdmin(t, req)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	req, _ = http.NewRequest("DELETE", "/api/admin/cache", nil)
+	authorizeAdmin(t, req)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	var purged map[string]int
+	json.Unmarshal(w.Body.Bytes(), &purged)
+	if purged["removed"] != 1 {
+		t.Errorf("Expected 1 render to be purged, got %d", purged["removed"])
+	}
+	if _, err := os.Stat(cache.path(keys[1])); !os.IsNotExist(err) {
+		t.Error("Expected purged renders to be removed from disk")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{
+		"0":     0,
+		"1024":  1024,
+		"500MB": 500 << 20,
+		"5gb":   5 << 30,
+		"64 KB": 64 << 10,
+		"2B":    2,
+	}
+	for value, expected := range tests {
+		size, err := parseSize(value)
+		if err != nil || size != expected {
+			t.Errorf("parseSize(%q) = %d, %v; expected %d", value, size, err, expected)
+		}
+	}
+
+	for _, value := range []string{"", "lots", "-1GB", "1.5GB"} {
+		if _, err := parseSize(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What processYoutube does with the chapters of a video.
+const (
+	// Keep one song and store the chapters as markers on it
+	ChaptersMarkers = "markers"
+	// Make one song per chapter, each separated on its own
+	ChaptersSplit = "split"
+)
+
+// Chapters shorter than this after trimming to a section are dropped.
+const minChapterLength = 1.0
+
+// chaptersInSection returns the video's chapters that fall within the
+// downloaded section, trimmed to it and relative to its start. An end of 0
+// means the end of the video.
+func (v *videoInfo) chaptersInSection(start, end float64) []Chapter {
+	if v == nil {
+		return nil
+	}
+	if end == 0 {
+		end = math.Inf(1)
+	}
+
+	var chapters []Chapter
+	for _, ch := range v.Chapters {
+		from := math.Max(ch.StartTime, start)
+		to := math.Min(ch.EndTime, end)
+		if to-from < minChapterLength {
+			continue
+		}
+		chapters = append(chapters, Chapter{Title: ch.Title, StartTime: from - start, EndTime: to - start})
+	}
+	return chapters
+}
+
+// splitChapters cuts the audio downloaded by job into one file per chapter
+// and queues a child job for each in a batch with the parent's ID.
+func (r *jobRegistry) splitChapters(ctx context.Context, job *Job, info *videoInfo, chapters []Chapter) error {
+	in := job.Input
+
+	batch := &ImportBatch{
+		ID:        job.ID,
+		Kind:      BatchKindChapters,
+		URL:       in.URL,
+		Title:     info.songName(),
+		CreatedAt: time.Now(),
+	}
+	if err := r.saveImportBatch(batch); err != nil {
+		return err
+	}
+
+	var children []*Job
+	for i, ch := range chapters {
+		id := uuid.New().String()
+		original := filepath.Join(config.Paths.Uploads, id+".mp3")
+
+		// Stream copy cuts on MP3 frame boundaries, which is close enough for
+		// chapter marks and avoids re-encoding
+		err := runStage(ctx, StageSplitting, stageLimits[StageMixing].Base,
+			"ffmpeg",
+			"-i", in.Original,
+			"-ss", strconv.FormatFloat(ch.StartTime, 'f', 3, 64),
+			"-to", strconv.FormatFloat(ch.EndTime, 'f', 3, 64),
+			"-c", "copy",
+			"-y", original)
+		if err != nil {
+			go r.runImportBatch(children)
+			return err
+		}
+
+		name := cleanSongName(ch.Title)
+		if name == "" {
+			name = fmt.Sprintf("%s - Chapter %d", info.songName(), i+1)
+		}
+
+		// The child's source is the chapter's part of the video
+		src := info.source(in.URL)
+		src.Chapters = nil
+		src.SectionStart = in.Start + ch.StartTime
+		src.SectionEnd = in.Start + ch.EndTime
+
+		child, err := r.createInBatch(batch.ID, id, JobKindChapter, JobInput{
+			URL:       in.URL,
+			Filename:  name + ".mp3",
+			Title:     name,
+			Original:  original,
+			Processed: filepath.Join(config.Paths.Processed, id+".mp3"),
+			ParentID:  job.ID,
+			Source:    src,
+		})
+		if err != nil {
+			go r.runImportBatch(children)
+			return err
+		}
+		children = append(children, child)
+	}
+
+	log.Printf("Split %s into %d chapters", in.URL, len(children))
+	go r.runImportBatch(children)
+	return nil
+}
+
+// processChapter removes the drums from one chapter cut by splitChapters and
+// adds it to the library, linked to the video it came from.
+func (r *jobRegistry) processChapter(ctx context.Context, job *Job) (*Song, error) {
+	song, err := r.processUpload(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	if src := job.Input.Source; src != nil {
+		if err := r.songs.SaveSource(ctx, song.ID, src); err != nil {
+			log.Printf("Failed to save source of song %s: %v", song.ID, err)
+		} else {
+			song.Source = src
+		}
+	}
+	return song, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChaptersInSection(t *testing.T) {
+	info := &videoInfo{Chapters: []Chapter{
+		{Title: "Intro", StartTime: 0, EndTime: 60},
+		{Title: "First Song", StartTime: 60, EndTime: 300},
+		{Title: "Second Song", StartTime: 300, EndTime: 540},
+		{Title: "Outro", StartTime: 540, EndTime: 600},
+	}}
+
+	// The whole video keeps every chapter as is
+	if chapters := info.chaptersInSection(0, 0); !reflect.DeepEqual(chapters, info.Chapters) {
+		t.Errorf("Expected all chapters, got %+v", chapters)
+	}
+
+	// A section trims the chapters to it and shifts them to its start
+	expected := []Chapter{
+		{Title: "First Song", StartTime: 0, EndTime: 240},
+		{Title: "Second Song", StartTime: 240, EndTime: 340},
+	}
+	if chapters := info.chaptersInSection(60, 400); !reflect.DeepEqual(chapters, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, chapters)
+	}
+
+	// Slivers of chapters at the edges of the section are dropped
+	if chapters := info.chaptersInSection(59.5, 300.5); len(chapters) != 1 || chapters[0].Title != "First Song" {
+		t.Errorf("Expected only the first song, got %+v", chapters)
+	}
+
+	var none *videoInfo
+	if chapters := none.chaptersInSection(0, 0); chapters != nil {
+		t.Errorf("Expected no chapters without metadata, got %+v", chapters)
+	}
+}
+
+func TestSongMarkers(t *testing.T) {
+	srv := setupTestDB(t)
+
+	song := &Song{ID: "album", Name: "Full Album", Original: "uploads/album.mp3", Processed: "processed/album.mp3", CreatedAt: time.Now()}
+	child := &Song{ID: "track-1", Name: "Track 1", Original: "uploads/track-1.mp3", Processed: "processed/track-1.mp3", ParentID: "album-job", CreatedAt: time.Now().Add(-time.Minute)}
+	srv.songs.SaveSong(context.Background(), song)
+	srv.songs.SaveSong(context.Background(), child)
+
+	markers := []Chapter{{Title: "Track 1", StartTime: 0, EndTime: 200}, {Title: "Track 2", StartTime: 200, EndTime: 410}}
+	if err := srv.songs.SaveMarkers(context.Background(), song.ID, markers); err != nil {
+		t.Fatalf("Failed to save markers: %v", err)
+	}
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("GET", "/api/songs", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var songs []Song
+	if err := json.Unmarshal(w.Body.Bytes(), &songs); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(songs) != 2 {
+		t.Fatalf("Expected 2 songs, got %d", len(songs))
+	}
+	if !reflect.DeepEqual(songs[0].Markers, markers) {
+		t.Errorf("Expected markers %+v, got %+v", markers, songs[0].Markers)
+	}
+	if songs[1].ParentID != "album-job" || songs[1].Markers != nil {
+		t.Errorf("Expected the child song to link to its parent without markers, got %+v", songs[1])
+	}
+
+	// Markers go with the song
+	if err := srv.songs.DeleteSong(context.Background(), song.ID); err != nil {
+		t.Fatal(err)
+	}
+	srv.songs.SaveSong(context.Background(), song)
+	if got, err := srv.songs.GetSong(context.Background(), song.ID); err != nil || got.Markers != nil {
+		t.Errorf("Expected markers to be deleted, got %+v, %v", got, err)
+	}
+}
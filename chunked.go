@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	StageSplitting = "splitting"
+	StageJoining   = "joining"
+)
+
+// The stems written by the 5-stem model
+var spleeterStems = []string{"vocals", "drums", "bass", "piano", "other"}
+
+// chunkSettings controls chunked separation: songs longer than Threshold are
+// split into segments of Length that overlap by Overlap, separated one at a
+// time and crossfaded back together, so Spleeter never holds a whole live
+// set in memory. A zero Threshold turns chunking off.
+type chunkSettings struct {
+	Threshold time.Duration
+	Length    time.Duration
+	Overlap   time.Duration
+}
+
+var chunking = chunkSettings{
+	Threshold: 20 * time.Minute,
+	Length:    10 * time.Minute,
+	Overlap:   10 * time.Second,
+}
+
+// loadChunkSettings applies the configured chunking.
+func loadChunkSettings() {
+	chunking.Threshold = config.Separation.ChunkThreshold
+	chunking.Length = config.Separation.ChunkLength
+	chunking.Overlap = config.Separation.ChunkOverlap
+}
+
+func (s chunkSettings) enabled(duration time.Duration) bool {
+	return s.Threshold > 0 && duration > s.Threshold
+}
+
+// segmentStarts returns the start time of each segment needed to cover
+// duration. Every segment but the last is s.Length long and starts s.Overlap
+// before the previous one ends.
+func (s chunkSettings) segmentStarts(duration time.Duration) []time.Duration {
+	step := s.Length - s.Overlap
+	starts := []time.Duration{0}
+	for start := step; start+s.Overlap < duration; start += step {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// separateChunked separates inputPath segment by segment and joins the stems
+// below outputDir in the same layout as separateStems.
+func separateChunked(ctx context.Context, inputPath, outputDir string, duration time.Duration) error {
+	baseName := stemsDirName(inputPath)
+	segmentsDir := filepath.Join(outputDir, "segments")
+	defer os.RemoveAll(segmentsDir)
+
+	if err := os.MkdirAll(segmentsDir, 0755); err != nil {
+		return err
+	}
+
+	starts := chunking.segmentStarts(duration)
+	log.Printf("Separating %s in %d segments", inputPath, len(starts))
+
+	stemsDir := filepath.Join(segmentsDir, "stems")
+	segmentNames := make([]string, len(starts))
+	for i, start := range starts {
+		// Segments of other songs may be separated in the same batch
+		segmentNames[i] = fmt.Sprintf("%s-segment-%03d", baseName, i)
+		segmentPath := filepath.Join(segmentsDir, segmentNames[i]+".wav")
+
+		length := chunking.Length
+		if remaining := duration - start; remaining < length {
+			length = remaining
+		}
+
+		// Decode to PCM so that every segment starts on the exact sample
+		// the join expects
+		err := runStage(ctx, StageSplitting, stageLimits[StageMixing].forDuration(length),
+			"ffmpeg",
+			"-ss", formatSeconds(start),
+			"-i", inputPath,
+			"-t", formatSeconds(chunking.Length),
+			"-c:a", "pcm_s16le",
+			"-ar", "44100",
+			"-ac", "2",
+			"-y", segmentPath)
+		if err != nil {
+			return err
+		}
+
+		if err := separateStems(ctx, segmentPath, stemsDir, length); err != nil {
+			return err
+		}
+		os.Remove(segmentPath)
+	}
+
+	setStage(ctx, StageJoining)
+
+	songDir := filepath.Join(outputDir, baseName)
+	if err := os.MkdirAll(songDir, 0755); err != nil {
+		return err
+	}
+	for _, stem := range spleeterStems {
+		paths := make([]string, len(segmentNames))
+		for i, name := range segmentNames {
+			paths[i] = filepath.Join(stemsDir, name, stem+".wav")
+		}
+		if err := joinSegments(paths, starts, filepath.Join(songDir, stem+".wav")); err != nil {
+			return &stageError{Stage: StageJoining, Err: fmt.Errorf("joining %s stems: %w", stem, err)}
+		}
+	}
+	return nil
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// joinSegments writes the segment WAV files at paths, which begin at starts
+// in the original audio, to output as one file. Where consecutive segments
+// overlap the first fades out linearly while the second fades in. The
+// segments must share a sample rate and channel count.
+func joinSegments(paths []string, starts []time.Duration, output string) error {
+	var out *wavWriter
+	var tail []float32
+
+	for i, path := range paths {
+		r, err := openWav(path)
+		if err != nil {
+			if out != nil {
+				out.Close()
+			}
+			return err
+		}
+
+		if out == nil {
+			out, err = createWav(output, r.Channels, r.Rate)
+			if err != nil {
+				r.Close()
+				return err
+			}
+		} else if r.Channels != out.Channels || r.Rate != out.Rate {
+			r.Close()
+			out.Close()
+			return fmt.Errorf("%s: expected %d channels at %d Hz, got %d at %d Hz", path, out.Channels, out.Rate, r.Channels, r.Rate)
+		}
+
+		err = joinSegment(out, r, &tail, starts, i)
+		r.Close()
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+
+	if out == nil {
+		return fmt.Errorf("no segments to join")
+	}
+	// A trailing overlap with no segment after it is kept as is
+	if err := out.Write(tail); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// joinSegment appends segment i to out, crossfading its start with the
+// previous segment's tail and holding back the part that overlaps the next
+// segment in tail.
+func joinSegment(out *wavWriter, r *wavReader, tail *[]float32, starts []time.Duration, i int) error {
+	head, err := r.ReadFrames(int64(len(*tail) / r.Channels))
+	if err != nil {
+		return err
+	}
+	crossfade((*tail)[:len(head)], head, r.Channels)
+	if err := out.Write(head); err != nil {
+		return err
+	}
+	*tail = nil
+
+	// Frames of this segment that belong to the overlap with the next one
+	var keep int64
+	if i+1 < len(starts) {
+		start := frameAt(starts[i], r.Rate)
+		next := frameAt(starts[i+1], r.Rate)
+		keep = start + r.Frames - next
+		if keep < 0 {
+			keep = 0
+		}
+	}
+
+	buf := make([]float32, 64*1024*r.Channels)
+	for remaining := r.Frames - r.read - keep; remaining > 0; {
+		chunk := buf
+		if n := remaining * int64(r.Channels); n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		frames, err := r.Read(chunk)
+		if err != nil {
+			return err
+		}
+		if err := out.Write(chunk[:frames*r.Channels]); err != nil {
+			return err
+		}
+		remaining -= int64(frames)
+	}
+
+	*tail, err = r.ReadFrames(keep)
+	return err
+}
+
+// crossfade fades from a into b over their length, writing the result to b.
+func crossfade(a, b []float32, channels int) {
+	frames := len(b) / channels
+	for f := 0; f < frames; f++ {
+		w := (float32(f) + 0.5) / float32(frames)
+		for c := 0; c < channels; c++ {
+			i := f*channels + c
+			b[i] = a[i]*(1-w) + b[i]*w
+		}
+	}
+}
+
+func frameAt(d time.Duration, rate int) int64 {
+	return int64(d.Seconds()*float64(rate) + 0.5)
+}
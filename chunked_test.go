@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSegmentStarts(t *testing.T) {
+	s := chunkSettings{Length: 10 * time.Minute, Overlap: 10 * time.Second}
+
+	tests := []struct {
+		duration time.Duration
+		expected int
+	}{
+		{5 * time.Minute, 1},
+		{10 * time.Minute, 1},
+		{10*time.Minute + time.Second, 2},
+		{60 * time.Minute, 7},
+	}
+
+	for _, test := range tests {
+		starts := s.segmentStarts(test.duration)
+		if len(starts) != test.expected {
+			t.Errorf("%v: expected %d segments, got %d", test.duration, test.expected, len(starts))
+			continue
+		}
+		for i := 1; i < len(starts); i++ {
+			if gap := starts[i] - starts[i-1]; gap != s.Length-s.Overlap {
+				t.Errorf("%v: segment %d starts %v after the previous one", test.duration, i, gap)
+			}
+		}
+		if end := starts[len(starts)-1] + s.Length; end < test.duration {
+			t.Errorf("%v: segments end at %v", test.duration, end)
+		}
+	}
+}
+
+func TestChunkingEnabled(t *testing.T) {
+	s := chunkSettings{Threshold: 20 * time.Minute}
+	if s.enabled(20 * time.Minute) {
+		t.Error("Expected chunking to be off at the threshold")
+	}
+	if !s.enabled(21 * time.Minute) {
+		t.Error("Expected chunking above the threshold")
+	}
+
+	s.Threshold = 0
+	if s.enabled(3 * time.Hour) {
+		t.Error("Expected a zero threshold to disable chunking")
+	}
+}
+
+func writeTestWav(t *testing.T, path string, rate int, samples []float32) {
+	t.Helper()
+	w, err := createWav(path, 1, rate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func constantSamples(n int, value float32) []float32 {
+	samples := make([]float32, n)
+	for i := range samples {
+		samples[i] = value
+	}
+	return samples
+}
+
+func TestJoinSegmentsCrossfades(t *testing.T) {
+	dir := t.TempDir()
+	const rate = 10
+
+	// Three 10 second segments overlapping by 2 seconds cover 26 seconds
+	paths := []string{
+		filepath.Join(dir, "0.wav"),
+		filepath.Join(dir, "1.wav"),
+		filepath.Join(dir, "2.wav"),
+	}
+	writeTestWav(t, paths[0], rate, constantSamples(100, 1))
+	writeTestWav(t, paths[1], rate, constantSamples(100, 0))
+	writeTestWav(t, paths[2], rate, constantSamples(100, 1))
+	starts := []time.Duration{0, 8 * time.Second, 16 * time.Second}
+
+	output := filepath.Join(dir, "joined.wav")
+	if err := joinSegments(paths, starts, output); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openWav(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.Frames != 260 {
+		t.Fatalf("Expected 260 frames, got %d", r.Frames)
+	}
+	samples, err := r.ReadFrames(r.Frames)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, s := range samples {
+		var expected float32
+		switch {
+		case i < 80:
+			expected = 1
+		case i < 100:
+			// Fading from the first segment into the second
+			expected = 1 - (float32(i-80)+0.5)/20
+		case i < 160:
+			expected = 0
+		case i < 180:
+			expected = (float32(i-160) + 0.5) / 20
+		default:
+			expected = 1
+		}
+		if math.Abs(float64(s-expected)) > 1e-6 {
+			t.Fatalf("Frame %d: expected %v, got %v", i, expected, s)
+		}
+	}
+}
+
+func TestJoinSegmentsRejectsMismatchedFormats(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{filepath.Join(dir, "0.wav"), filepath.Join(dir, "1.wav")}
+	writeTestWav(t, paths[0], 10, constantSamples(100, 0))
+	writeTestWav(t, paths[1], 20, constantSamples(100, 0))
+
+	err := joinSegments(paths, []time.Duration{0, 8 * time.Second}, filepath.Join(dir, "joined.wav"))
+	if err == nil {
+		t.Error("Expected an error for segments with different sample rates")
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const usage = `Usage: drummer [command] [arguments]
+
+Commands:
+  serve                          Run the web server (the default)
+  process <input> -o <output>    Remove the drums from one file, without the library
+  import <dir>                   Add every audio file below dir to the library
+  export <dir>                   Copy the songs of the library to dir
+  migrate status                 List the database migrations and whether they ran
+  version                        Print the version
+
+Run "drummer <command> -h" for the options of a command.
+`
+
+// errUsage means the arguments were invalid and the usage has been printed.
+var errUsage = errors.New("invalid arguments")
+
+func main() {
+	os.Exit(runCommand(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// runCommand runs the command named by the first argument and returns the
+// exit code. Without arguments the web server is started.
+func runCommand(args []string, stdout, stderr io.Writer) int {
+	name := "serve"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	var err error
+	switch name {
+	case "serve":
+		err = serveCommand(args, stderr)
+	case "process":
+		err = processCommand(args, stdout, stderr)
+	case "import":
+		err = importCommand(args, stdout, stderr)
+	case "export":
+		err = exportCommand(args, stdout, stderr)
+	case "migrate":
+		err = migrateCommand(args, stdout, stderr)
+	case "version":
+		fmt.Fprintln(stdout, Version)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	default:
+		fmt.Fprintf(stderr, "drummer %s: %v\n", name, err)
+		return 1
+	}
+}
+
+func newFlagSet(name, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: drummer %s %s\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args with flags, allowing flags after the positional
+// arguments, and returns the positional arguments.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, errUsage
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// setupConfig loads the configuration of a command and applies it.
+func setupConfig(cf *configFlags) error {
+	cfg, err := loadConfig(cf)
+	if err != nil {
+		return err
+	}
+	applyConfig(cfg)
+	return nil
+}
+
+func serveCommand(args []string, stderr io.Writer) error {
+	flags := newFlagSet("serve", "[flags]", stderr)
+	cf := addConfigFlags(flags)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	if err := setupConfig(cf); err != nil {
+		return err
+	}
+	return serve()
+}
+
+func processCommand(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("process", "<input> -o <output> [-stems vocals,bass,...]", stderr)
+	output := flags.String("o", "", "`file` to write the mix to, as MP3")
+	stemList := flags.String("stems", strings.Join(drumlessStems, ","),
+		"comma-separated `stems` to keep, of "+strings.Join(spleeterStems, ", "))
+	cf := addConfigFlags(flags)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *output == "" {
+		flags.Usage()
+		return errUsage
+	}
+
+	stems, err := parseStems(*stemList)
+	if err != nil {
+		return err
+	}
+	input := positional[0]
+	if _, err := os.Stat(input); err != nil {
+		return err
+	}
+
+	if err := setupConfig(cf); err != nil {
+		return err
+	}
+	workDir, err := os.MkdirTemp("", "drummer-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	// Stop Spleeter and FFmpeg on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := mixStems(ctx, input, *output, workDir, stems); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Wrote %s\n", *output)
+	return nil
+}
+
+// parseStems parses a comma-separated list of stems of the 5-stem model.
+func parseStems(list string) ([]string, error) {
+	var stems []string
+	for _, stem := range strings.Split(list, ",") {
+		stem = strings.ToLower(strings.TrimSpace(stem))
+		if stem == "" || slices.Contains(stems, stem) {
+			continue
+		}
+		if !slices.Contains(spleeterStems, stem) {
+			return nil, fmt.Errorf("unknown stem %q, expected some of %s", stem, strings.Join(spleeterStems, ", "))
+		}
+		stems = append(stems, stem)
+	}
+	if len(stems) == 0 {
+		return nil, errors.New("no stems to mix")
+	}
+	return stems, nil
+}
+
+func importCommand(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("import", "[-duplicate new] <dir>", stderr)
+	duplicate := flags.String("duplicate", DuplicateExisting,
+		`"new" to add files that are already in the library as separate songs`)
+	cf := addConfigFlags(flags)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || (*duplicate != DuplicateExisting && *duplicate != DuplicateNew) {
+		flags.Usage()
+		return errUsage
+	}
+
+	if err := setupConfig(cf); err != nil {
+		return err
+	}
+	db := initDB()
+	defer db.Close()
+	makeDataDirs()
+	startSeparatorWorker()
+
+	return importFolder(newJobRegistry(db, newSQLSongStore(db)), positional[0], *duplicate, stdout)
+}
+
+// importFolder adds every audio file below dir to the library of jobs as a
+// batch of upload jobs, processes them and reports each file that was not
+// imported to out. Hidden files and folders are skipped.
+func importFolder(jobs *jobRegistry, dir, duplicate string, out io.Writer) error {
+	batch := &ImportBatch{
+		ID:        uuid.New().String(),
+		Kind:      BatchKindFolder,
+		Title:     filepath.Base(dir),
+		CreatedAt: time.Now(),
+	}
+	if err := jobs.saveImportBatch(batch); err != nil {
+		return err
+	}
+
+	var queued []*Job
+	paths := make(map[string]string)
+	counts := make(map[string]int)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := entry.Name()
+		if path != dir && strings.HasPrefix(name, ".") {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || !audioExtensions[strings.ToLower(filepath.Ext(name))] {
+			return nil
+		}
+
+		result := UploadResult{Filename: name, Status: UploadFailed, Error: "Failed to read file"}
+		var job *Job
+		if f, err := os.Open(path); err == nil {
+			result, job = jobs.queueUploadFile(context.Background(), batch.ID, name, f, duplicate)
+			f.Close()
+		}
+
+		counts[result.Status]++
+		switch result.Status {
+		case UploadQueued:
+			queued = append(queued, job)
+			paths[job.ID] = path
+		case UploadDuplicate:
+			fmt.Fprintf(out, "%s: already in the library\n", path)
+		default:
+			fmt.Fprintf(out, "%s: %s\n", path, result.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(queued) > 0 {
+		fmt.Fprintf(out, "Processing %d files\n", len(queued))
+		jobs.runImportBatch(queued)
+	}
+
+	imported, failed := 0, counts[UploadFailed]
+	for _, job := range queued {
+		stored, err := jobs.getJobByID(job.ID)
+		if err == nil && stored.Status == JobCompleted {
+			imported++
+			continue
+		}
+		failed++
+		message := "Processing failed"
+		if err == nil && stored.Error != "" {
+			message = stored.Error
+		}
+		fmt.Fprintf(out, "%s: %s\n", paths[job.ID], message)
+	}
+
+	fmt.Fprintf(out, "Imported %d songs, %d already in the library, %d failed\n", imported, counts[UploadDuplicate], failed)
+	if failed > 0 {
+		return fmt.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+func exportCommand(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("export", "[-originals] <dir>", stderr)
+	originals := flags.Bool("originals", false, "also export the original files")
+	cf := addConfigFlags(flags)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	if err := setupConfig(cf); err != nil {
+		return err
+	}
+	db := initDB()
+	defer db.Close()
+	return exportLibrary(newSQLSongStore(db), positional[0], *originals, stdout)
+}
+
+// migrateCommand reports the state of the schema. The migrations themselves
+// run whenever the database is opened, by serve, import and export.
+func migrateCommand(args []string, stdout, stderr io.Writer) error {
+	flags := newFlagSet("migrate", "status", stderr)
+	cf := addConfigFlags(flags)
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || positional[0] != "status" {
+		flags.Usage()
+		return errUsage
+	}
+
+	if err := setupConfig(cf); err != nil {
+		return err
+	}
+	// Opening a missing database file would create it
+	if config.Database.DSN == "" {
+		if _, err := os.Stat(config.Paths.Database); err != nil {
+			return err
+		}
+	}
+	db := openDB()
+	defer db.Close()
+
+	statuses, err := db.migrationStatus()
+	if err != nil {
+		return err
+	}
+	printMigrationStatus(statuses, stdout)
+	return nil
+}
+
+// exportLibrary copies the drumless mix of every song in library to dir,
+// named after the song like a download, and the original too if originals
+// is set. songs.json lists the songs with the names of their exported files.
+func exportLibrary(library SongStore, dir string, originals bool, out io.Writer) error {
+	songs, err := library.ListSongs(context.Background())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	used := make(map[string]bool)
+	manifest := make([]*Song, 0, len(songs))
+	for _, song := range songs {
+		base := safeFileName(song.Name)
+		if base == "" {
+			base = song.ID
+		}
+		name := base
+		for i := 1; used[name]; i++ {
+			name = fmt.Sprintf("%s (%d)", base, i)
+		}
+		used[name] = true
+
+		exported := *song
+		exported.Processed = name + "_no_drums.mp3"
+		if err := copyFile(song.Processed, filepath.Join(dir, exported.Processed)); err != nil {
+			return fmt.Errorf("failed to export %s: %w", song.Name, err)
+		}
+		exported.Original = ""
+		if originals {
+			exported.Original = name + "_original.mp3"
+			if err := copyFile(song.Original, filepath.Join(dir, exported.Original)); err != nil {
+				return fmt.Errorf("failed to export %s: %w", song.Name, err)
+			}
+		}
+		manifest = append(manifest, &exported)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "songs.json"), data, 0644); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Exported %d songs to %s\n", len(songs), dir)
+	return nil
+}
+
+// safeFileName replaces the characters of name that are not allowed in file
+// names on common systems.
+func safeFileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 32 || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	return strings.TrimSpace(name)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommandUsage(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"version"}, 0},
+		{[]string{"help"}, 0},
+		{[]string{"frobnicate"}, 2},
+		{[]string{"process", "in.mp3"}, 2},
+		{[]string{"process", "-h"}, 0},
+		{[]string{"process", "in.mp3", "-o", "out.mp3", "-stems", "cowbell"}, 1},
+		{[]string{"import"}, 2},
+		{[]string{"import", "-duplicate", "maybe", "music"}, 2},
+		{[]string{"export", "a", "b"}, 2},
+		{[]string{"serve", "now"}, 2},
+	}
+
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		if code := runCommand(test.args, &stdout, &stderr); code != test.code {
+			t.Errorf("%v: Expected exit code %d, got %d: %s", test.args, test.code, code, stderr.String())
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	flags := flag.NewFlagSet("process", flag.ContinueOnError)
+	output := flags.String("o", "", "")
+	stems := flags.String("stems", "", "")
+
+	positional, err := parseFlags(flags, []string{"in.mp3", "-o", "out.mp3", "--stems", "vocals", "extra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(positional, []string{"in.mp3", "extra"}) || *output != "out.mp3" || *stems != "vocals" {
+		t.Errorf("Expected flags after positional arguments to be parsed, got %v %q %q", positional, *output, *stems)
+	}
+}
+
+func TestParseStems(t *testing.T) {
+	stems, err := parseStems(" Vocals,bass,,vocals ")
+	if err != nil || !reflect.DeepEqual(stems, []string{"vocals", "bass"}) {
+		t.Errorf("Expected vocals and bass, got %v, %v", stems, err)
+	}
+
+	for _, list := range []string{"", "guitar", "vocals,kazoo"} {
+		if _, err := parseStems(list); err == nil {
+			t.Errorf("%q: Expected an error", list)
+		}
+	}
+}
+
+func TestImportFolder(t *testing.T) {
+	srv := setupTestDB(t)
+
+	saveHashedSong(t, srv.songs, "known", []byte("known song"))
+
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "Album"), 0755)
+	os.MkdirAll(filepath.Join(dir, ".trash"), 0755)
+	os.WriteFile(filepath.Join(dir, "Album", "01 Known.mp3"), []byte("known song"), 0644)
+	os.WriteFile(filepath.Join(dir, "Album", "cover.jpg"), []byte("image"), 0644)
+	os.WriteFile(filepath.Join(dir, ".trash", "Deleted.mp3"), []byte("deleted song"), 0644)
+
+	var out bytes.Buffer
+	if err := importFolder(srv.jobs, dir, DuplicateExisting, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "01 Known.mp3: already in the library") {
+		t.Errorf("Expected the known song to be reported, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "Imported 0 songs, 1 already in the library, 0 failed") {
+		t.Errorf("Expected a summary, got %q", out.String())
+	}
+	if strings.Contains(out.String(), "Deleted.mp3") || strings.Contains(out.String(), "cover.jpg") {
+		t.Errorf("Expected hidden folders and other files to be skipped, got %q", out.String())
+	}
+}
+
+func TestExportLibrary(t *testing.T) {
+	srv := setupTestDB(t)
+
+	first := saveHashedSong(t, srv.songs, "first", []byte("first song"))
+	second := saveHashedSong(t, srv.songs, "second", []byte("second song"))
+	srv.songs.RenameSong(context.Background(), second.ID, "AC/DC: Back in Black")
+
+	dir := filepath.Join(t.TempDir(), "export")
+	var out bytes.Buffer
+	if err := exportLibrary(srv.songs, dir, true, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"Setlist Opener_no_drums.mp3",
+		"Setlist Opener_original.mp3",
+		"AC_DC_ Back in Black_no_drums.mp3",
+		"AC_DC_ Back in Black_original.mp3",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("Expected %s to be exported: %v", name, err)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "Setlist Opener_original.mp3")); string(data) != "first song" {
+		t.Errorf("Expected the original content, got %q", data)
+	}
+
+	var manifest []*Song
+	data, err := os.ReadFile(filepath.Join(dir, "songs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal(data, &manifest)
+	if len(manifest) != 2 {
+		t.Fatalf("Expected 2 songs in the manifest, got %d", len(manifest))
+	}
+	for _, song := range manifest {
+		if song.ID == first.ID && song.Processed != "Setlist Opener_no_drums.mp3" {
+			t.Errorf("Expected the manifest to name the exported file, got %q", song.Processed)
+		}
+	}
+}
+
+// fakeFFmpeg puts stand-ins for ffmpeg and ffprobe first on the PATH. ffprobe
+// reports a duration of seconds; ffmpeg fails if one of its inputs is
+// missing and otherwise writes an empty output file.
+func fakeFFmpeg(t *testing.T, seconds int) {
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"ffprobe": fmt.Sprintf("#!/bin/sh\necho %d\n", seconds),
+		"ffmpeg": `#!/bin/sh
+while [ $# -gt 1 ]; do
+	if [ "$1" = -i ] && [ ! -e "$2" ]; then
+		echo "$2: No such file or directory" >&2
+		exit 1
+	fi
+	shift
+done
+: > "$1"
+`,
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// fakeSeparations replaces Spleeter with a separator that writes short
+// stems, long enough to join, for every input.
+func fakeSeparations(t *testing.T) {
+	saved := separations
+	separations = newSeparationBatcher(1, time.Hour, func(ctx context.Context, inputs []string, outputDir string, limit time.Duration) error {
+		for _, input := range inputs {
+			dir := filepath.Join(outputDir, stemsDirName(input))
+			os.MkdirAll(dir, 0755)
+			for _, stem := range spleeterStems {
+				w, err := createWav(filepath.Join(dir, stem+".wav"), 2, 44100)
+				if err != nil {
+					return err
+				}
+				w.Write(make([]float32, 2*100))
+				w.Close()
+			}
+		}
+		return nil
+	})
+	t.Cleanup(func() { separations = saved })
+}
+
+func TestProcessCommandChunksWavInput(t *testing.T) {
+	useTestDataDirs(t)
+	fakeFFmpeg(t, 150)
+	t.Setenv("CHUNKED_SEPARATION_THRESHOLD", "1m")
+	t.Setenv("CHUNK_LENGTH", "1m")
+	t.Cleanup(func() { applyConfig(configDefaults) })
+
+	fakeSeparations(t)
+
+	dir := t.TempDir()
+	input := filepath.Join(dir, "long.wav")
+	output := filepath.Join(dir, "out.mp3")
+	os.WriteFile(input, []byte("audio"), 0644)
+
+	var stdout, stderr bytes.Buffer
+	if code := runCommand([]string{"process", input, "-o", output}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("Expected the mix to be written: %v", err)
+	}
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Directory holding the cookie files passed to yt-dlp, one per user plus a
+// default. It is only readable by the server's user.
+var cookiesDir = filepath.Join("data", "cookies")
+
+// Cookie files are small; anything larger is not one.
+const maxCookieFileSize = 1 << 20
+
+const defaultCookieUser = "default"
+
+// Name of the copy of the cookies in a job's temp directory.
+const jobCookieFile = "cookies.txt"
+
+var cookieUserPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Cookies that YouTube needs to treat a request as signed in.
+var youtubeLoginCookies = map[string]bool{
+	"SID":               true,
+	"HSID":              true,
+	"SSID":              true,
+	"APISID":            true,
+	"SAPISID":           true,
+	"LOGIN_INFO":        true,
+	"__Secure-1PSID":    true,
+	"__Secure-3PSID":    true,
+	"__Secure-1PAPISID": true,
+	"__Secure-3PAPISID": true,
+}
+
+// CookieFileStatus describes a stored cookie file without revealing any
+// cookie values.
+type CookieFileStatus struct {
+	User         string   `json:"user"`
+	Cookies      int      `json:"cookies"`
+	Domains      []string `json:"domains"`
+	LoginCookies int      `json:"login_cookies"`
+	// Earliest expiry of the YouTube login cookies; nil if they only last
+	// for the session
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// loadCookieSettings applies the configured cookies directory.
+func loadCookieSettings() {
+	cookiesDir = config.Paths.Cookies
+}
+
+// parseCookieFile validates a Netscape cookies file, the format written by
+// browser extensions and read by yt-dlp, and summarizes it.
+func parseCookieFile(data []byte, now time.Time) (*CookieFileStatus, error) {
+	status := &CookieFileStatus{Domains: []string{}}
+	domains := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// HttpOnly cookies are written as comments with a prefix
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab-separated fields, got %d", n, len(fields))
+		}
+		if fields[1] != "TRUE" && fields[1] != "FALSE" || fields[3] != "TRUE" && fields[3] != "FALSE" {
+			return nil, fmt.Errorf("line %d: flags must be TRUE or FALSE", n)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", n, fields[4])
+		}
+
+		domain := strings.TrimPrefix(fields[0], ".")
+		status.Cookies++
+		if !domains[domain] {
+			domains[domain] = true
+			status.Domains = append(status.Domains, domain)
+		}
+
+		if !strings.HasSuffix(domain, "youtube.com") || !youtubeLoginCookies[fields[5]] {
+			continue
+		}
+		status.LoginCookies++
+		if expires > 0 {
+			t := time.Unix(expires, 0).UTC()
+			if status.ExpiresAt == nil || t.Before(*status.ExpiresAt) {
+				status.ExpiresAt = &t
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if status.Cookies == 0 {
+		return nil, errors.New("no cookies found; export the file in Netscape format")
+	}
+	sort.Strings(status.Domains)
+	status.Expired = status.ExpiresAt != nil && status.ExpiresAt.Before(now)
+	return status, nil
+}
+
+func cookiePath(user string) string {
+	if user == "" {
+		user = defaultCookieUser
+	}
+	return filepath.Join(cookiesDir, user+".txt")
+}
+
+// invalidCookieFileError is returned by saveCookieFile for a file that is
+// not a usable cookie file, as opposed to one that could not be stored.
+type invalidCookieFileError struct {
+	Err error
+}
+
+func (e *invalidCookieFileError) Error() string {
+	return fmt.Sprintf("invalid cookies file: %v", e.Err)
+}
+
+func (e *invalidCookieFileError) Unwrap() error {
+	return e.Err
+}
+
+// saveCookieFile validates data and stores it as the cookie file of user,
+// or the default one if user is empty.
+func saveCookieFile(user string, data []byte) (*CookieFileStatus, error) {
+	status, err := parseCookieFile(data, time.Now())
+	if err != nil {
+		return nil, &invalidCookieFileError{err}
+	}
+
+	if err := os.MkdirAll(cookiesDir, 0700); err != nil {
+		return nil, err
+	}
+	path := cookiePath(user)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+
+	status.User = cookieUserName(user)
+	status.UpdatedAt = time.Now()
+	return status, nil
+}
+
+func cookieUserName(user string) string {
+	if user == "" {
+		return defaultCookieUser
+	}
+	return user
+}
+
+// cookieFileStatus summarizes the stored cookie file of user. The error
+// wraps os.ErrNotExist if there is none.
+func cookieFileStatus(user string) (*CookieFileStatus, error) {
+	path := cookiePath(user)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := parseCookieFile(data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	status.User = cookieUserName(user)
+	status.UpdatedAt = info.ModTime()
+	return status, nil
+}
+
+// resolveCookieUser returns the user whose cookie file a download for user
+// uses: their own if they have one, otherwise the default. ok is false if
+// there is no cookie file to use.
+func resolveCookieUser(user string) (string, bool) {
+	if user != "" {
+		if _, err := os.Stat(cookiePath(user)); err == nil {
+			return user, true
+		}
+	}
+	if _, err := os.Stat(cookiePath("")); err == nil {
+		return "", true
+	}
+	return "", false
+}
+
+// cookieArgs returns the yt-dlp arguments for downloading as user. yt-dlp
+// writes the cookie jar back when it exits, so it gets a private copy in
+// workDir rather than the stored file.
+func cookieArgs(user, workDir string) ([]string, error) {
+	owner, ok := resolveCookieUser(user)
+	if !ok {
+		return nil, nil
+	}
+
+	src, err := os.Open(cookiePath(owner))
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	path := filepath.Join(workDir, jobCookieFile)
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return nil, err
+	}
+	return []string{"--cookies", path}, nil
+}
+
+// cookieHint explains how cookies relate to a download that failed because
+// YouTube wants a signed-in user, or returns "" if the failure was not
+// about signing in.
+func cookieHint(err error, user string) string {
+	var yerr *ytdlpError
+	if !errors.As(err, &yerr) || !yerr.NeedsLogin() {
+		return ""
+	}
+
+	owner, ok := resolveCookieUser(user)
+	if !ok {
+		return "Upload a YouTube cookies file to download this video"
+	}
+
+	status, serr := cookieFileStatus(owner)
+	switch {
+	case serr != nil:
+		log.Printf("Failed to read cookie file of %s: %v", cookieUserName(owner), serr)
+		return "The stored YouTube cookies could not be read, please upload them again"
+	case status.Expired:
+		return fmt.Sprintf("The YouTube cookies of %s expired on %s, please upload a new cookies file", status.User, status.ExpiresAt.Format("2006-01-02"))
+	case status.LoginCookies == 0:
+		return fmt.Sprintf("The cookies of %s do not include a YouTube login", status.User)
+	default:
+		return fmt.Sprintf("The YouTube cookies of %s were rejected, they may have been signed out", status.User)
+	}
+}
+
+func uploadCookies(c *gin.Context) {
+	user := c.PostForm("user")
+	if user != "" && !cookieUserPattern.MatchString(user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user name"})
+		return
+	}
+
+	file, _, err := c.Request.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxCookieFileSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file"})
+		return
+	}
+	if len(data) > maxCookieFileSize {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cookies file is too large"})
+		return
+	}
+
+	status, err := saveCookieFile(user, data)
+	var ierr *invalidCookieFileError
+	if errors.As(err, &ierr) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid cookies file: %v", ierr.Err)})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store cookie file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store cookies file"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+func getCookies(c *gin.Context) {
+	entries, err := os.ReadDir(cookiesDir)
+	if err != nil && !os.IsNotExist(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read cookie files"})
+		return
+	}
+
+	list := make([]*CookieFileStatus, 0)
+	for _, entry := range entries {
+		user, ok := strings.CutSuffix(entry.Name(), ".txt")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if user == defaultCookieUser {
+			user = ""
+		}
+
+		status, err := cookieFileStatus(user)
+		if err != nil {
+			log.Printf("Failed to read cookie file %s: %v", entry.Name(), err)
+			continue
+		}
+		list = append(list, status)
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func deleteCookies(c *gin.Context) {
+	user := c.Query("user")
+	if user != "" && !cookieUserPattern.MatchString(user) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user name"})
+		return
+	}
+
+	err := os.Remove(cookiePath(user))
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cookies file not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete cookies file"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Cookies file deleted successfully"})
+}
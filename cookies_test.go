@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useTestCookiesDir points the cookie store at a temporary directory.
+func useTestCookiesDir(t *testing.T) string {
+	t.Helper()
+
+	old := cookiesDir
+	cookiesDir = filepath.Join(t.TempDir(), "cookies")
+	t.Cleanup(func() { cookiesDir = old })
+	return cookiesDir
+}
+
+// cookieFile returns a Netscape cookies file with a YouTube login that
+// expires at expires.
+func cookieFile(expires time.Time) []byte {
+	return []byte(fmt.Sprintf(`# Netscape HTTP Cookie File
+# This file is generated by a browser extension.
+
+.youtube.com	TRUE	/	TRUE	%d	SID	secret-sid
+#HttpOnly_.youtube.com	TRUE	/	TRUE	%d	__Secure-3PSID	secret-3psid
+.youtube.com	TRUE	/	FALSE	0	PREF	f6=40000000
+.google.com	TRUE	/	TRUE	%d	NID	secret-nid
+`, expires.Unix(), expires.Add(time.Hour).Unix(), expires.Add(24*time.Hour).Unix()))
+}
+
+func newCookiesRequest(t *testing.T, content []byte, user string) *http.Request {
+	t.Helper()
+
+	fields := map[string]string{}
+	if user != "" {
+		fields["user"] = user
+	}
+	req := newUploadRequest(t, "cookies.txt", content, fields)
+	req.Method = "PUT"
+	req.URL.Path = "/api/admin/cookies"
+	return authorizeAdmin(t, req)
+}
+
+func TestParseCookieFile(t *testing.T) {
+	now := time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC)
+	expires := now.Add(30 * 24 * time.Hour)
+
+	status, err := parseCookieFile(cookieFile(expires), now)
+	if err != nil {
+		t.Fatalf("Failed to parse cookie file: %v", err)
+	}
+	if status.Cookies != 4 {
+		t.Errorf("Expected 4 cookies, got %d", status.Cookies)
+	}
+	if len(status.Domains) != 2 || status.Domains[0] != "google.com" || status.Domains[1] != "youtube.com" {
+		t.Errorf("Expected google.com and youtube.com, got %v", status.Domains)
+	}
+	// HttpOnly cookies count, session cookies are not logins
+	if status.LoginCookies != 2 {
+		t.Errorf("Expected 2 login cookies, got %d", status.LoginCookies)
+	}
+	if status.ExpiresAt == nil || !status.ExpiresAt.Equal(expires) {
+		t.Errorf("Expected the earliest login expiry %v, got %v", expires, status.ExpiresAt)
+	}
+	if status.Expired {
+		t.Error("Expected the cookies not to be expired")
+	}
+
+	status, err = parseCookieFile(cookieFile(expires), expires.Add(time.Minute))
+	if err != nil || !status.Expired {
+		t.Errorf("Expected the cookies to be expired, got %+v, %v", status, err)
+	}
+}
+
+func TestParseCookieFileInvalid(t *testing.T) {
+	tests := map[string]string{
+		"empty":       "# Netscape HTTP Cookie File\n\n",
+		"json":        `[{"domain": ".youtube.com", "name": "SID"}]`,
+		"fields":      ".youtube.com\tTRUE\t/\tTRUE\tSID\tvalue\n",
+		"flags":       ".youtube.com\tyes\t/\tTRUE\t0\tSID\tvalue\n",
+		"expiry":      ".youtube.com\tTRUE\t/\tTRUE\tnever\tSID\tvalue\n",
+		"spaces":      ".youtube.com TRUE / TRUE 0 SID value\n",
+		"second line": ".youtube.com\tTRUE\t/\tTRUE\t0\tSID\tvalue\nbroken\n",
+	}
+
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseCookieFile([]byte(content), time.Now()); err == nil {
+				t.Error("Expected the cookie file to be rejected")
+			}
+		})
+	}
+}
+
+func TestUploadCookies(t *testing.T) {
+	dir := useTestCookiesDir(t)
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newCookiesRequest(t, cookieFile(time.Now().Add(time.Hour)), ""))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "secret") {
+		t.Error("Expected the response not to include cookie values")
+	}
+
+	var status CookieFileStatus
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if status.User != defaultCookieUser || status.LoginCookies != 2 {
+		t.Errorf("Expected the default user with 2 login cookies, got %+v", status)
+	}
+
+	info, err := os.Stat(filepath.Join(dir, "default.txt"))
+	if err != nil {
+		t.Fatalf("Expected the cookie file to be stored: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the cookie file to be private, got %v", info.Mode().Perm())
+	}
+
+	// A user gets their own file
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newCookiesRequest(t, cookieFile(time.Now().Add(-time.Hour)), "alex"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/admin/cookies", nil)
+	authorizeAdmin(t, req)
+	router.ServeHTTP(w, req)
+	var list []CookieFileStatus
+	json.Unmarshal(w.Body.Bytes(), &list)
+	if len(list) != 2 || list[0].User != "alex" || !list[0].Expired || list[1].User != defaultCookieUser || list[1].Expired {
+		t.Errorf("Expected expired cookies for alex and valid default cookies, got %+v", list)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/api/admin/cookies?user=alex", nil)
+	authorizeAdmin(t, req)
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "alex.txt")); !os.IsNotExist(err) {
+		t.Error("Expected the cookie file of alex to be deleted")
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestUploadCookiesInvalid(t *testing.T) {
+	dir := useTestCookiesDir(t)
+	router := setupRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newCookiesRequest(t, []byte("not cookies\n"), ""))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newCookiesRequest(t, cookieFile(time.Now()), "../admin"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be stored")
+	}
+}
+
+func TestCookieArgs(t *testing.T) {
+	useTestCookiesDir(t)
+	work := t.TempDir()
+
+	args, err := cookieArgs("alex", work)
+	if err != nil || args != nil {
+		t.Fatalf("Expected no arguments without cookies, got %v, %v", args, err)
+	}
+
+	if _, err := saveCookieFile("", cookieFile(time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Users without their own cookies use the default ones, through a copy
+	// that yt-dlp may overwrite
+	args, err = cookieArgs("alex", work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(work, jobCookieFile)
+	if len(args) != 2 || args[0] != "--cookies" || args[1] != path {
+		t.Fatalf("Expected --cookies %s, got %v", path, args)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.Contains(string(data), "secret-sid") {
+		t.Errorf("Expected the default cookies to be copied, got %v", err)
+	}
+
+	if _, err := saveCookieFile("alex", []byte(".youtube.com\tTRUE\t/\tTRUE\t0\tSID\talex-sid\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cookieArgs("alex", work); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = os.ReadFile(path)
+	if !strings.Contains(string(data), "alex-sid") {
+		t.Error("Expected the cookies of alex to be used")
+	}
+}
+
+func TestCookieHint(t *testing.T) {
+	useTestCookiesDir(t)
+
+	loginErr := &ytdlpError{Code: YtdlpAgeRestricted, Err: errors.New("exit status 1")}
+	if hint := cookieHint(&ytdlpError{Code: YtdlpUnavailable}, ""); hint != "" {
+		t.Errorf("Expected no hint for an unavailable video, got %q", hint)
+	}
+	if hint := cookieHint(loginErr, ""); !strings.Contains(hint, "Upload") {
+		t.Errorf("Expected a hint to upload cookies, got %q", hint)
+	}
+
+	expired := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	if _, err := saveCookieFile("alex", cookieFile(expired)); err != nil {
+		t.Fatal(err)
+	}
+	if hint := cookieHint(loginErr, "alex"); !strings.Contains(hint, "expired on 2026-01-02") {
+		t.Errorf("Expected the expiry to be reported, got %q", hint)
+	}
+
+	if _, err := saveCookieFile("alex", cookieFile(time.Now().Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+	if hint := cookieHint(&jobError{"Failed", loginErr}, "alex"); !strings.Contains(hint, "rejected") {
+		t.Errorf("Expected valid cookies to be reported as rejected, got %q", hint)
+	}
+}
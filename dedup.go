@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// What uploadSong does with a file that is already in the library, chosen
+// with the "duplicate" form field.
+const (
+	// Return the song that is already there
+	DuplicateExisting = "existing"
+	// Add another song that shares the stored files
+	DuplicateNew = "new"
+)
+
+// Songs with the same content share their original and processed files. A
+// file's reference count is the number of songs pointing at it, and
+// libraryFiles is held while songs are linked to or unlinked from files so
+// that a file is never deleted while a new song starts using it.
+var libraryFiles sync.Mutex
+
+// findDuplicate looks for a song with the given content hash. It returns nil
+// in songs if there is none; otherwise either that song or, with
+// DuplicateNew, a new song named after filename that shares its files.
+func findDuplicate(ctx context.Context, songs SongStore, contentHash, mode, filename string) (*Song, error) {
+	libraryFiles.Lock()
+	defer libraryFiles.Unlock()
+
+	existing, err := songs.GetSongByHash(ctx, contentHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if mode == DuplicateExisting {
+		return existing, nil
+	}
+
+	song := &Song{
+		ID:          uuid.New().String(),
+		Name:        strings.TrimSuffix(filename, ".mp3"),
+		Original:    existing.Original,
+		Processed:   existing.Processed,
+		ContentHash: contentHash,
+		CreatedAt:   time.Now(),
+	}
+	if err := songs.SaveSong(ctx, song); err != nil {
+		return nil, err
+	}
+	return song, nil
+}
+
+// deleteSongAndFiles removes song from songs and deletes each of its files
+// that no other song references.
+func deleteSongAndFiles(ctx context.Context, songs SongStore, song *Song) error {
+	libraryFiles.Lock()
+	defer libraryFiles.Unlock()
+
+	if err := songs.DeleteSong(ctx, song.ID); err != nil {
+		return err
+	}
+
+	for _, path := range []string{song.Original, song.Processed} {
+		refs, err := songs.FileReferences(ctx, path)
+		if err != nil {
+			// Leaving a file behind is better than breaking another song
+			return err
+		}
+		if refs == 0 {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newUploadRequest(t *testing.T, filename string, content []byte, fields map[string]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		w.WriteField(name, value)
+	}
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	w.Close()
+
+	req, _ := http.NewRequest("POST", "/api/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+// saveHashedSong adds a processed song with content to the library.
+func saveHashedSong(t *testing.T, songs SongStore, id string, content []byte) *Song {
+	t.Helper()
+
+	os.MkdirAll(config.Paths.Uploads, 0755)
+	os.MkdirAll(config.Paths.Processed, 0755)
+
+	sum := sha256.Sum256(content)
+	song := &Song{
+		ID:          id,
+		Name:        "Setlist Opener",
+		Original:    filepath.Join(config.Paths.Uploads, id+".mp3"),
+		Processed:   filepath.Join(config.Paths.Processed, id+".mp3"),
+		ContentHash: hex.EncodeToString(sum[:]),
+		CreatedAt:   time.Now(),
+	}
+	os.WriteFile(song.Original, content, 0644)
+	os.WriteFile(song.Processed, []byte("no drums"), 0644)
+	t.Cleanup(func() {
+		os.Remove(song.Original)
+		os.Remove(song.Processed)
+	})
+
+	if err := songs.SaveSong(context.Background(), song); err != nil {
+		t.Fatalf("Failed to save test song: %v", err)
+	}
+	return song
+}
+
+func TestUploadDuplicateReturnsExistingSong(t *testing.T) {
+	srv := setupTestDB(t)
+
+	content := []byte("ID3 opener")
+	existing := saveHashedSong(t, srv.songs, "dedup-existing", content)
+
+	router := setupServerRouter(srv)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequest(t, "opener.mp3", content, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var song Song
+	json.Unmarshal(w.Body.Bytes(), &song)
+	if song.ID != existing.ID {
+		t.Errorf("Expected the existing song %s, got %s", existing.ID, song.ID)
+	}
+
+	songs, _ := srv.songs.ListSongs(context.Background())
+	if len(songs) != 1 {
+		t.Errorf("Expected 1 song in the library, got %d", len(songs))
+	}
+	uploads, _ := filepath.Glob(filepath.Join(config.Paths.Uploads, "*.mp3"))
+	if len(uploads) != 1 {
+		t.Errorf("Expected the duplicate upload to be discarded, found %v", uploads)
+	}
+}
+
+func TestUploadDuplicateSharesFiles(t *testing.T) {
+	srv := setupTestDB(t)
+
+	content := []byte("ID3 closer")
+	existing := saveHashedSong(t, srv.songs, "dedup-shared", content)
+
+	router := setupServerRouter(srv)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequest(t, "closer (take 2).mp3", content, map[string]string{"duplicate": DuplicateNew}))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var song Song
+	json.Unmarshal(w.Body.Bytes(), &song)
+	if song.ID == existing.ID {
+		t.Fatal("Expected a new library entry")
+	}
+	if song.Name != "closer (take 2)" {
+		t.Errorf("Expected name %q, got %q", "closer (take 2)", song.Name)
+	}
+	if song.Original != existing.Original || song.Processed != existing.Processed {
+		t.Errorf("Expected the new song to share files with the existing one, got %+v", song)
+	}
+
+	// Deleting one of the songs keeps the files for the other
+	req, _ := http.NewRequest("DELETE", "/api/songs/"+existing.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	for _, path := range []string{song.Original, song.Processed} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept for the remaining song: %v", path, err)
+		}
+	}
+
+	// Deleting the last song removes them
+	req, _ = http.NewRequest("DELETE", "/api/songs/"+song.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	for _, path := range []string{song.Original, song.Processed} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be deleted with the last song", path)
+		}
+	}
+}
+
+func TestUploadInvalidDuplicateMode(t *testing.T) {
+	srv := setupTestDB(t)
+
+	router := setupServerRouter(srv)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequest(t, "song.mp3", []byte("ID3"), map[string]string{"duplicate": "sometimes"}))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestInitDBUpgradesOldDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "old.db")
+	useTestDatabase(dbPath)
+
+	// A songs table as created by earlier versions, without content_hash
+	old, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE songs (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		original_path TEXT NOT NULL,
+		processed_path TEXT NOT NULL,
+		created_at DATETIME NOT NULL
+	)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`INSERT INTO songs VALUES ('old-song', 'Old Song', 'uploads/old.mp3', 'processed/old.mp3', ?)`, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	db := initDB()
+	defer db.Close()
+
+	song, err := newSQLSongStore(db).GetSong(context.Background(), "old-song")
+	if err != nil {
+		t.Fatalf("Failed to read song from upgraded database: %v", err)
+	}
+	if song.ContentHash != "" {
+		t.Errorf("Expected no content hash for an old song, got %q", song.ContentHash)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	BatchKindPlaylist = "playlist"
+	// The chapters of one video, split into songs. The batch has the ID of
+	// the job that downloaded the video.
+	BatchKindChapters = "chapters"
+	// The files of one multi-file or archive upload
+	BatchKindUpload = "upload"
+	// A music folder loaded with "drummer import"
+	BatchKindFolder = "folder"
+)
+
+// Number of jobs of one import batch processed at the same time. Downloads
+// overlap while separation is serialized by the separation batcher anyway.
+const importConcurrency = 2
+
+// ImportBatch groups the jobs started by a single import request, such as
+// every selected video of a playlist.
+type ImportBatch struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	URL       string    `json:"url,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// "running" while any item is queued or running, then "finished"
+	Status string            `json:"status"`
+	Counts map[JobStatus]int `json:"counts"`
+	Items  []*BatchItem      `json:"items"`
+}
+
+// BatchItem is one job of a batch along with what it imports.
+type BatchItem struct {
+	*Job
+	URL   string `json:"url,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+// saveImportBatch stores a batch, or updates it if it exists, as when the job
+// that splits a video into chapters is retried.
+func (r *jobRegistry) saveImportBatch(batch *ImportBatch) error {
+	query := `INSERT INTO import_batches (id, kind, url, title, created_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET kind = excluded.kind, url = excluded.url, title = excluded.title`
+	_, err := r.db.Exec(r.db.rebind(query), batch.ID, batch.Kind, batch.URL, batch.Title, batch.CreatedAt)
+	return err
+}
+
+// getImportBatchByID loads a batch along with the current state of its jobs.
+func (r *jobRegistry) getImportBatchByID(id string) (*ImportBatch, error) {
+	var batch ImportBatch
+	err := r.db.QueryRow(r.db.rebind(`SELECT id, kind, url, title, created_at FROM import_batches WHERE id = ?`), id).
+		Scan(&batch.ID, &batch.Kind, &batch.URL, &batch.Title, &batch.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := r.queryJobs(`SELECT `+jobColumns+` FROM jobs WHERE batch_id = ? ORDER BY created_at, id`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	batch.Status = "finished"
+	batch.Counts = make(map[JobStatus]int)
+	batch.Items = make([]*BatchItem, 0, len(list))
+	for _, job := range list {
+		batch.Counts[job.Status]++
+		if job.Status == JobQueued || job.Status == JobRunning {
+			batch.Status = "running"
+		}
+		batch.Items = append(batch.Items, &BatchItem{Job: job, URL: job.Input.URL, Title: job.Input.Title})
+	}
+	return &batch, nil
+}
+
+// runImportBatch processes queued jobs in the background, importConcurrency
+// at a time, skipping any that are cancelled before their turn.
+func (r *jobRegistry) runImportBatch(queued []*Job) {
+	work := make(chan *Job)
+	var wg sync.WaitGroup
+	for i := 0; i < importConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				if _, err := r.runQueued(job); err != nil {
+					log.Printf("Batch job %s failed: %v", job.ID, err)
+				}
+			}
+		}()
+	}
+
+	for _, job := range queued {
+		work <- job
+	}
+	close(work)
+	wg.Wait()
+}
+
+func (s *server) getImportBatch(c *gin.Context) {
+	batch, err := s.jobs.getImportBatchByID(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Batch not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch batch"})
+		return
+	}
+
+	c.JSON(http.StatusOK, batch)
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Domains that POST /api/import accepts URLs from, including their
+// subdomains. "*" allows any domain.
+var importDomains = []string{"youtube.com", "youtu.be", "soundcloud.com", "bandcamp.com"}
+
+// Largest audio file downloaded from a direct link.
+var maxImportSize int64 = 500 << 20
+
+// Audio formats accepted from direct links and resumable uploads. Links to
+// files with these extensions are downloaded directly instead of through
+// yt-dlp.
+var audioExtensions = map[string]bool{
+	".mp3":  true,
+	".wav":  true,
+	".flac": true,
+	".ogg":  true,
+	".m4a":  true,
+	".aac":  true,
+	".opus": true,
+}
+
+// HTTP client for direct downloads. Redirects must stay on allowed domains.
+var importClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !domainAllowed(req.URL.Hostname()) {
+			return &importError{http.StatusForbidden, "domain_not_allowed", fmt.Errorf("redirected to %s", req.URL.Hostname())}
+		}
+		return nil
+	},
+}
+
+// importError is a direct download that failed because of what the remote
+// server returned. Code is reported to API clients like the yt-dlp codes.
+type importError struct {
+	Status int
+	Code   string
+	Err    error
+}
+
+func (e *importError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *importError) Unwrap() error {
+	return e.Err
+}
+
+// loadImportSettings applies the configured allowed domains and maximum
+// size.
+func loadImportSettings() {
+	importDomains = nil
+	for _, domain := range config.Import.AllowedDomains {
+		importDomains = append(importDomains, strings.ToLower(domain))
+	}
+	maxImportSize = int64(config.Import.MaxSize)
+}
+
+// domainAllowed reports whether host is an allowed domain or a subdomain of
+// one.
+func domainAllowed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, domain := range importDomains {
+		if domain == "*" || host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkImportURL parses the URL of a download request and responds with an
+// error if it is not an http or https URL on an allowed domain.
+func checkImportURL(c *gin.Context, rawURL string) (*url.URL, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An http or https URL is required"})
+		return nil, false
+	}
+	if !domainAllowed(u.Hostname()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Imports from %s are not allowed", u.Hostname()), "code": "domain_not_allowed"})
+		return nil, false
+	}
+	return u, true
+}
+
+// isDirectAudio reports whether u links to an audio file rather than a page
+// for yt-dlp to extract it from.
+func isDirectAudio(u *url.URL) bool {
+	return audioExtensions[strings.ToLower(path.Ext(u.Path))]
+}
+
+// importURL adds the audio at any allowed URL to the library: audio files
+// are downloaded directly and other pages go through yt-dlp, which supports
+// SoundCloud, Bandcamp and many other sites besides YouTube.
+func (s *server) importURL(c *gin.Context) {
+	var req downloadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	u, ok := checkImportURL(c, req.URL)
+	if !ok {
+		return
+	}
+
+	if !isDirectAudio(u) {
+		s.startDownload(c, JobKindYoutube, &req)
+		return
+	}
+
+	if req.Start != "" || req.End != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Time ranges are not supported for audio files"})
+		return
+	}
+	s.startDownload(c, JobKindDirect, &req)
+}
+
+// processDirect downloads an audio file from a direct link, converts it to
+// MP3 if needed and processes it like an upload.
+func (r *jobRegistry) processDirect(ctx context.Context, job *Job) (*Song, error) {
+	tempDir := jobTempDir(job.ID)
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return nil, &jobError{"Failed to create temp directory", err}
+	}
+
+	downloaded, name, err := fetchAudio(ctx, job.Input.URL, tempDir)
+	if err != nil {
+		return nil, &jobError{fetchErrorMessage(err), err}
+	}
+
+	if err := convertToMP3(ctx, downloaded, job.Input.Original); err != nil {
+		return nil, &jobError{"Failed to read the downloaded audio", err}
+	}
+
+	hash, err := hashFile(job.Input.Original)
+	if err != nil {
+		return nil, &jobError{"Failed to read the downloaded audio", err}
+	}
+
+	r.update(job, func(j *Job) {
+		j.Input.Filename = name + ".mp3"
+		j.Input.ContentHash = hash
+	})
+
+	song, err := r.processUpload(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	src := &Source{URL: job.Input.URL}
+	if err := r.songs.SaveSource(ctx, song.ID, src); err != nil {
+		log.Printf("Failed to save source of song %s: %v", song.ID, err)
+	} else {
+		song.Source = src
+	}
+	return song, nil
+}
+
+// convertToMP3 writes the audio file src to dst as MP3, copying it if it is
+// one already.
+func convertToMP3(ctx context.Context, src, dst string) error {
+	var err error
+	if strings.EqualFold(filepath.Ext(src), ".mp3") {
+		err = copyFile(src, dst)
+	} else {
+		args := append([]string{"-i", src, "-vn"}, config.Encoding.args()...)
+		err = runStage(ctx, StageMixing, stageLimits[StageMixing].Base, "ffmpeg", append(args, "-y", dst)...)
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// fetchAudio downloads the audio file at rawURL into dir, enforcing the
+// size limit and checking that the server says it is audio. It returns the
+// path of the file and a song name for it.
+func fetchAudio(ctx context.Context, rawURL, dir string) (string, string, error) {
+	if err := waitForImportRequest(ctx); err != nil {
+		return "", "", err
+	}
+	setStage(ctx, StageDownloading)
+
+	limit := stageLimits[StageDownloading].Base
+	fetchCtx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+
+	// Turns a failure caused by the time limit into a stage timeout, as if
+	// the download had run as a tool
+	timedOut := func(err error) error {
+		if ctx.Err() == nil && errors.Is(fetchCtx.Err(), context.DeadlineExceeded) {
+			return &stageError{Stage: StageDownloading, TimedOut: true, Limit: limit, Err: err}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", getRandomUserAgent())
+
+	resp, err := importClient.Do(req)
+	if err != nil {
+		if terr := timedOut(err); terr != nil {
+			return "", "", terr
+		}
+		var ierr *importError
+		if errors.As(err, &ierr) {
+			return "", "", ierr
+		}
+		return "", "", &importError{http.StatusBadGateway, "network_error", err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return "", "", &importError{http.StatusNotFound, "file_not_found", fmt.Errorf("server returned %s", resp.Status)}
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return "", "", &importError{http.StatusBadGateway, "download_failed", fmt.Errorf("server returned %s", resp.Status)}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "audio/") && mediaType != "application/octet-stream" && mediaType != "binary/octet-stream" {
+		return "", "", &importError{http.StatusUnsupportedMediaType, "unsupported_content_type", fmt.Errorf("content type %q is not audio", mediaType)}
+	}
+	if resp.ContentLength > maxImportSize {
+		return "", "", &importError{http.StatusRequestEntityTooLarge, "file_too_large", fmt.Errorf("file is %d bytes", resp.ContentLength)}
+	}
+
+	name := remoteFileName(resp)
+	ext := strings.ToLower(path.Ext(name))
+	if !audioExtensions[ext] {
+		ext = strings.ToLower(path.Ext(resp.Request.URL.Path))
+	}
+	downloaded := filepath.Join(dir, "download"+ext)
+
+	out, err := os.Create(downloaded)
+	if err != nil {
+		return "", "", err
+	}
+	defer out.Close()
+
+	body := newThrottledReader(fetchCtx, io.LimitReader(resp.Body, maxImportSize+1), downloadRateLimit)
+	n, err := io.Copy(out, body)
+	if err != nil {
+		if terr := timedOut(err); terr != nil {
+			return "", "", terr
+		}
+		return "", "", &importError{http.StatusBadGateway, "network_error", err}
+	}
+	if n > maxImportSize {
+		return "", "", &importError{http.StatusRequestEntityTooLarge, "file_too_large", fmt.Errorf("file is larger than %d bytes", maxImportSize)}
+	}
+
+	name = cleanSongName(strings.TrimSuffix(name, path.Ext(name)))
+	if name == "" {
+		name = "Imported audio"
+	}
+	return downloaded, name, out.Sync()
+}
+
+// remoteFileName is the name of a downloaded file, from the response
+// headers or else the last part of the URL.
+func remoteFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return path.Base(params["filename"])
+	}
+	name, err := url.PathUnescape(path.Base(resp.Request.URL.Path))
+	if err != nil {
+		return path.Base(resp.Request.URL.Path)
+	}
+	return name
+}
+
+func fetchErrorMessage(err error) string {
+	var ierr *importError
+	if !errors.As(err, &ierr) {
+		return "Failed to download the audio file"
+	}
+
+	switch ierr.Code {
+	case "domain_not_allowed":
+		return "The link redirects to a domain that is not allowed"
+	case "file_not_found":
+		return "Audio file not found"
+	case "unsupported_content_type":
+		return "The link does not point to an audio file"
+	case "file_too_large":
+		return fmt.Sprintf("The audio file is larger than %d MB", maxImportSize>>20)
+	case "network_error":
+		return "Network error: Unable to reach the server"
+	default:
+		return "Failed to download the audio file"
+	}
+}
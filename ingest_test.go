@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// allowImportDomains replaces the import allowlist for a test.
+func allowImportDomains(t *testing.T, domains ...string) {
+	t.Helper()
+
+	old := importDomains
+	importDomains = domains
+	t.Cleanup(func() { importDomains = old })
+}
+
+func newAudioServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/song.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("ID3 audio"))
+	})
+	mux.HandleFunc("/named.wav", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/wav")
+		w.Header().Set("Content-Disposition", `attachment; filename="Live Set.wav"`)
+		w.Write([]byte("RIFF audio"))
+	})
+	mux.HandleFunc("/page.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/big.mp3", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(bytes.Repeat([]byte("a"), 2048))
+	})
+	mux.HandleFunc("/away.mp3", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://elsewhere.test/song.mp3", http.StatusFound)
+	})
+	mux.HandleFunc("/slow.mp3", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestDomainAllowed(t *testing.T) {
+	allowImportDomains(t, "youtube.com", "bandcamp.com")
+
+	for host, allowed := range map[string]bool{
+		"youtube.com":         true,
+		"music.youtube.com":   true,
+		"artist.bandcamp.com": true,
+		"YouTube.com.":        true,
+		"notyoutube.com":      false,
+		"youtube.com.evil":    false,
+		"soundcloud.com":      false,
+	} {
+		if domainAllowed(host) != allowed {
+			t.Errorf("Expected %s allowed to be %v", host, allowed)
+		}
+	}
+
+	allowImportDomains(t, "*")
+	if !domainAllowed("example.com") {
+		t.Error("Expected * to allow any domain")
+	}
+}
+
+func TestFetchAudio(t *testing.T) {
+	server := newAudioServer(t)
+	allowImportDomains(t, "127.0.0.1")
+	dir := t.TempDir()
+
+	path, name, err := fetchAudio(context.Background(), server.URL+"/song.mp3", dir)
+	if err != nil {
+		t.Fatalf("Failed to fetch audio: %v", err)
+	}
+	if name != "song" || !strings.HasSuffix(path, ".mp3") {
+		t.Errorf("Expected an MP3 named song, got %s at %s", name, path)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "ID3 audio" {
+		t.Errorf("Expected the file to be downloaded, got %q", data)
+	}
+
+	path, name, err = fetchAudio(context.Background(), server.URL+"/named.wav", dir)
+	if err != nil {
+		t.Fatalf("Failed to fetch audio: %v", err)
+	}
+	if name != "Live Set" || !strings.HasSuffix(path, ".wav") {
+		t.Errorf("Expected a WAV named after the Content-Disposition, got %s at %s", name, path)
+	}
+}
+
+func TestFetchAudioErrors(t *testing.T) {
+	server := newAudioServer(t)
+	allowImportDomains(t, "127.0.0.1")
+
+	oldSize := maxImportSize
+	maxImportSize = 1024
+	t.Cleanup(func() { maxImportSize = oldSize })
+
+	tests := []struct {
+		path   string
+		status int
+		code   string
+	}{
+		{"/missing.mp3", http.StatusNotFound, "file_not_found"},
+		{"/page.mp3", http.StatusUnsupportedMediaType, "unsupported_content_type"},
+		{"/big.mp3", http.StatusRequestEntityTooLarge, "file_too_large"},
+		{"/away.mp3", http.StatusForbidden, "domain_not_allowed"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			_, _, err := fetchAudio(context.Background(), server.URL+test.path, t.TempDir())
+			var ierr *importError
+			if !errors.As(err, &ierr) {
+				t.Fatalf("Expected an import error, got %v", err)
+			}
+			if ierr.Status != test.status || ierr.Code != test.code {
+				t.Errorf("Expected %d %s, got %d %s", test.status, test.code, ierr.Status, ierr.Code)
+			}
+		})
+	}
+}
+
+func TestFetchAudioTimeout(t *testing.T) {
+	server := newAudioServer(t)
+	allowImportDomains(t, "127.0.0.1")
+
+	old := stageLimits[StageDownloading]
+	stageLimits[StageDownloading] = stageLimit{Base: 50 * time.Millisecond}
+	t.Cleanup(func() { stageLimits[StageDownloading] = old })
+
+	_, _, err := fetchAudio(context.Background(), server.URL+"/slow.mp3", t.TempDir())
+	var serr *stageError
+	if !errors.As(err, &serr) || !serr.TimedOut {
+		t.Errorf("Expected a download timeout, got %v", err)
+	}
+}
+
+func TestImportURLValidation(t *testing.T) {
+	srv := setupTestDB(t)
+	allowImportDomains(t, "youtube.com", "example.com")
+	router := setupServerRouter(srv)
+
+	tests := []struct {
+		body   map[string]string
+		status int
+	}{
+		{map[string]string{"url": ""}, http.StatusBadRequest},
+		{map[string]string{"url": "ftp://example.com/song.mp3"}, http.StatusBadRequest},
+		{map[string]string{"url": "https://soundcloud.com/artist/track"}, http.StatusForbidden},
+		{map[string]string{"url": "https://example.com/song.mp3", "start": "1:00"}, http.StatusBadRequest},
+		{map[string]string{"url": "https://www.youtube.com/watch?v=abc", "chapters": "both"}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		body, _ := json.Marshal(test.body)
+		req, _ := http.NewRequest("POST", "/api/import", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%v: Expected status code %d, got %d", test.body, test.status, w.Code)
+		}
+	}
+}
+
+func TestDownloadEndpointsRejectBlockedHosts(t *testing.T) {
+	allowImportDomains(t, "youtube.com")
+	router := setupRouter()
+
+	tests := []struct {
+		path   string
+		url    string
+		status int
+	}{
+		{"/api/youtube", "http://169.254.169.254/latest/meta-data", http.StatusForbidden},
+		{"/api/youtube", "http://localhost:8080/api/songs", http.StatusForbidden},
+		{"/api/youtube", "file:///etc/passwd", http.StatusBadRequest},
+		{"/api/youtube/playlist", "http://internal.example/playlist", http.StatusForbidden},
+		{"/api/youtube/playlist", "ytsearch10:drum covers", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		body, _ := json.Marshal(map[string]string{"url": test.url})
+		req, _ := http.NewRequest("POST", test.path, bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != test.status {
+			t.Errorf("%s %s: Expected status code %d, got %d", test.path, test.url, test.status, w.Code)
+		}
+	}
+}
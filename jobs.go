@@ -0,0 +1,624 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCancelled JobStatus = "cancelled"
+)
+
+const (
+	JobKindUpload  = "upload"
+	JobKindYoutube = "youtube"
+	// One chapter of a YouTube download, cut from the parent's audio
+	JobKindChapter = "chapter"
+	// An audio file downloaded from a direct link
+	JobKindDirect = "direct"
+)
+
+// A job interrupted by a restart is re-queued until it has been started this
+// many times, then marked failed.
+const maxJobAttempts = 3
+
+// Grace period for a cancelled command's output pipes to close before Wait
+// gives up on them.
+const commandWaitDelay = 5 * time.Second
+
+// JobInput holds everything needed to run a job again from scratch, so that
+// jobs interrupted by a restart can be re-queued.
+type JobInput struct {
+	URL         string `json:"url,omitempty"`
+	Filename    string `json:"filename,omitempty"`
+	Original    string `json:"original"`
+	Processed   string `json:"processed"`
+	ContentHash string `json:"content_hash,omitempty"`
+	// Title of a playlist entry or name of an uploaded file, shown while it
+	// waits in its batch
+	Title string `json:"title,omitempty"`
+	// Section of a video to download, in seconds; an End of 0 means the
+	// end of the video
+	Start float64 `json:"start,omitempty"`
+	End   float64 `json:"end,omitempty"`
+	// What to do with the chapters of a video: ChaptersMarkers or
+	// ChaptersSplit
+	Chapters string `json:"chapters,omitempty"`
+	// Whose YouTube cookies to download with; the default cookies are used
+	// if the user has none
+	User string `json:"user,omitempty"`
+	// File received by a resumable upload in a format other than MP3; it is
+	// converted into Original when the job runs
+	Upload string `json:"upload,omitempty"`
+	// The job that created this one, and the source of its audio
+	ParentID string  `json:"parent_id,omitempty"`
+	Source   *Source `json:"source,omitempty"`
+}
+
+// Job tracks a single upload or YouTube download while it is processed. Jobs
+// are persisted so that they can be inspected, cancelled and recovered after
+// a restart.
+type Job struct {
+	ID        string    `json:"id"`
+	Kind      string    `json:"kind"`
+	Status    JobStatus `json:"status"`
+	Stage     string    `json:"stage,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Output    string    `json:"output,omitempty"`
+	Attempts  int       `json:"attempts"`
+	BatchID   string    `json:"batch_id,omitempty"`
+	Input     JobInput  `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// jobError pairs a processing failure with the message shown to API clients.
+type jobError struct {
+	Message string
+	Err     error
+}
+
+func (e *jobError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Message, e.Err)
+}
+
+func (e *jobError) Unwrap() error {
+	return e.Err
+}
+
+type jobContextKey struct{}
+
+// runningJob is carried by the context of a running job, so that the stages
+// of its pipeline can report their progress.
+type runningJob struct {
+	registry *jobRegistry
+	job      *Job
+}
+
+// jobRegistry keeps the jobs running in this process so they can be
+// cancelled, and mirrors every state change to the jobs table of db.
+// Finished jobs add their songs to songs and keep their renders in renders.
+type jobRegistry struct {
+	mu      sync.Mutex
+	active  map[string]*Job
+	db      *database
+	songs   SongStore
+	renders *renderCache
+}
+
+func newJobRegistry(db *database, songs SongStore) *jobRegistry {
+	return &jobRegistry{
+		active:  make(map[string]*Job),
+		db:      db,
+		songs:   songs,
+		renders: newRenderCache(db),
+	}
+}
+
+// jobTempDir returns the scratch directory owned by a job. It is removed when
+// the job finishes, whatever the outcome.
+func jobTempDir(id string) string {
+	return filepath.Join(config.Paths.Temp, id)
+}
+
+func (r *jobRegistry) saveJob(job *Job) error {
+	input, err := json.Marshal(job.Input)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO jobs (` + jobColumns + `, instance) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err = r.db.Exec(r.db.rebind(query), job.ID, job.Kind, job.Status, job.Stage, job.Error, job.Reason, job.Output, job.Attempts, job.BatchID, string(input), job.CreatedAt, job.UpdatedAt, config.Server.Instance)
+	return err
+}
+
+func (r *jobRegistry) updateJob(job *Job) error {
+	query := `UPDATE jobs SET status = ?, stage = ?, error = ?, reason = ?, output = ?, attempts = ?, updated_at = ? WHERE id = ?`
+	_, err := r.db.Exec(r.db.rebind(query), job.Status, job.Stage, job.Error, job.Reason, job.Output, job.Attempts, job.UpdatedAt, job.ID)
+	return err
+}
+
+const jobColumns = `id, kind, status, stage, error, reason, output, attempts, batch_id, input, created_at, updated_at`
+
+func scanJob(scanner interface{ Scan(...any) error }) (*Job, error) {
+	var job Job
+	var input string
+	err := scanner.Scan(&job.ID, &job.Kind, &job.Status, &job.Stage, &job.Error, &job.Reason, &job.Output, &job.Attempts, &job.BatchID, &input, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(input), &job.Input); err != nil {
+		return nil, fmt.Errorf("invalid input for job %s: %w", job.ID, err)
+	}
+	return &job, nil
+}
+
+func (r *jobRegistry) getJobByID(id string) (*Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE id = ?`
+	return scanJob(r.db.QueryRow(r.db.rebind(query), id))
+}
+
+func (r *jobRegistry) queryJobs(query string, args ...any) ([]*Job, error) {
+	rows, err := r.db.Query(r.db.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, job)
+	}
+	return list, rows.Err()
+}
+
+func (r *jobRegistry) getAllJobs() ([]*Job, error) {
+	return r.queryJobs(`SELECT ` + jobColumns + ` FROM jobs ORDER BY created_at DESC`)
+}
+
+// getUnfinishedJobs returns the jobs that were queued or running when the
+// server last stopped. Only those of this instance are returned, and those
+// created before jobs had one, as other instances may still be running
+// theirs.
+func (r *jobRegistry) getUnfinishedJobs() ([]*Job, error) {
+	return r.queryJobs(`SELECT `+jobColumns+` FROM jobs WHERE status IN (?, ?) AND instance IN (?, '') ORDER BY created_at`,
+		JobQueued, JobRunning, config.Server.Instance)
+}
+
+// create persists a new queued job.
+func (r *jobRegistry) create(id, kind string, input JobInput) (*Job, error) {
+	return r.createInBatch("", id, kind, input)
+}
+
+// createInBatch persists a new queued job that belongs to an import batch.
+func (r *jobRegistry) createInBatch(batchID, id, kind string, input JobInput) (*Job, error) {
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		Kind:      kind,
+		Status:    JobQueued,
+		BatchID:   batchID,
+		Input:     input,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := r.saveJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// update applies fn to job under the registry lock and persists the result.
+func (r *jobRegistry) update(job *Job, fn func(*Job)) {
+	r.mu.Lock()
+	fn(job)
+	job.UpdatedAt = time.Now()
+	snapshot := *job
+	r.mu.Unlock()
+
+	if err := r.updateJob(&snapshot); err != nil {
+		log.Printf("Failed to persist job %s: %v", job.ID, err)
+	}
+}
+
+// run processes job under a context derived from parent and records the
+// outcome. The context is cancelled when the parent is (e.g. the client
+// disconnects) or when the job is cancelled through the API; the returned
+// error then wraps context.Canceled.
+func (r *jobRegistry) run(parent context.Context, job *Job) (*Song, error) {
+	if !r.activate(parent, job) {
+		return nil, fmt.Errorf("job %s is already running", job.ID)
+	}
+	return r.execute(job)
+}
+
+// activate marks job as running in this process under a context derived
+// from parent. It returns false if the job is already active.
+func (r *jobRegistry) activate(parent context.Context, job *Job) bool {
+	r.mu.Lock()
+	if _, ok := r.active[job.ID]; ok {
+		r.mu.Unlock()
+		return false
+	}
+	r.register(parent, job)
+	r.mu.Unlock()
+
+	r.markRunning(job)
+	return true
+}
+
+// activateQueued is like activate for a job that has been waiting its turn.
+// It returns false if the job was cancelled in the meantime.
+func (r *jobRegistry) activateQueued(parent context.Context, job *Job) bool {
+	r.mu.Lock()
+	if _, ok := r.active[job.ID]; ok {
+		r.mu.Unlock()
+		return false
+	}
+	current, err := r.getJobByID(job.ID)
+	if err != nil || current.Status != JobQueued {
+		r.mu.Unlock()
+		return false
+	}
+	r.register(parent, job)
+	r.mu.Unlock()
+
+	r.markRunning(job)
+	return true
+}
+
+// register adds job to the active jobs. The caller must hold r.mu.
+func (r *jobRegistry) register(parent context.Context, job *Job) {
+	ctx, cancel := context.WithCancel(parent)
+	job.ctx = context.WithValue(ctx, jobContextKey{}, runningJob{registry: r, job: job})
+	job.cancel = cancel
+	r.active[job.ID] = job
+}
+
+func (r *jobRegistry) markRunning(job *Job) {
+	r.update(job, func(j *Job) {
+		j.Status = JobRunning
+		j.Stage = ""
+		j.Attempts++
+	})
+}
+
+// runQueued processes a job that has been waiting its turn, unless it was
+// cancelled while it waited.
+func (r *jobRegistry) runQueued(job *Job) (*Song, error) {
+	if !r.activateQueued(context.Background(), job) {
+		log.Printf("Skipping job %s, it is no longer queued", job.ID)
+		return nil, nil
+	}
+	return r.execute(job)
+}
+
+// execute processes an activated job and records the outcome.
+func (r *jobRegistry) execute(job *Job) (*Song, error) {
+	song, err := r.processJob(job.ctx, job)
+	if err != nil && job.ctx.Err() != nil {
+		err = fmt.Errorf("job %s cancelled: %w", job.ID, context.Canceled)
+	}
+	r.finish(job, err)
+	return song, err
+}
+
+// processJob runs the pipeline for the job's kind.
+func (r *jobRegistry) processJob(ctx context.Context, job *Job) (*Song, error) {
+	switch job.Kind {
+	case JobKindUpload:
+		return r.processUpload(ctx, job)
+	case JobKindYoutube:
+		return r.processYoutube(ctx, job)
+	case JobKindChapter:
+		return r.processChapter(ctx, job)
+	case JobKindDirect:
+		return r.processDirect(ctx, job)
+	default:
+		return nil, fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
+
+// setStage records the processing stage of the job running under ctx. It is
+// a no-op when ctx does not belong to a job.
+func setStage(ctx context.Context, stage string) {
+	running, ok := ctx.Value(jobContextKey{}).(runningJob)
+	if !ok {
+		return
+	}
+
+	running.registry.update(running.job, func(j *Job) {
+		j.Stage = stage
+	})
+}
+
+// finish records the outcome of a job, releases its context and removes its
+// temp directory.
+func (r *jobRegistry) finish(job *Job, err error) {
+	job.cancel()
+
+	if rmErr := os.RemoveAll(jobTempDir(job.ID)); rmErr != nil {
+		log.Printf("Failed to remove temp directory for job %s: %v", job.ID, rmErr)
+	}
+
+	r.mu.Lock()
+	delete(r.active, job.ID)
+	r.mu.Unlock()
+
+	r.update(job, func(j *Job) {
+		switch {
+		case errors.Is(err, context.Canceled):
+			j.Status = JobCancelled
+		case err != nil:
+			j.Status = JobFailed
+			j.Error = err.Error()
+			j.Reason = "error"
+			var serr *stageError
+			if errors.As(err, &serr) {
+				j.Output = serr.Output
+				if serr.TimedOut {
+					j.Reason = "timeout"
+				}
+			}
+			var yerr *ytdlpError
+			if errors.As(err, &yerr) {
+				j.Reason = string(yerr.Code)
+			}
+			var ierr *importError
+			if errors.As(err, &ierr) {
+				j.Reason = ierr.Code
+			}
+		default:
+			j.Status = JobCompleted
+			j.Error = ""
+			j.Reason = ""
+			j.Output = ""
+		}
+	})
+}
+
+// cancel stops a running job, or marks a queued one cancelled so that it is
+// skipped when its turn comes. It returns false if the job is neither running
+// in this process nor queued.
+func (r *jobRegistry) cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.active[id]
+	if ok {
+		job.cancel()
+		return true
+	}
+
+	queued, err := r.getJobByID(id)
+	if err != nil || queued.Status != JobQueued {
+		return false
+	}
+	queued.Status = JobCancelled
+	queued.UpdatedAt = time.Now()
+	if err := r.updateJob(queued); err != nil {
+		log.Printf("Failed to persist job %s: %v", id, err)
+		return false
+	}
+	return true
+}
+
+// recoverJobs handles jobs left queued or running by a previous process. Their
+// partial artifacts are removed, then they are re-queued or, once they have
+// used up their attempts, marked failed. It returns the re-queued jobs.
+func (r *jobRegistry) recoverJobs() ([]*Job, error) {
+	unfinished, err := r.getUnfinishedJobs()
+	if err != nil {
+		return nil, err
+	}
+
+	var requeued []*Job
+	for _, job := range unfinished {
+		os.RemoveAll(jobTempDir(job.ID))
+		os.Remove(job.Input.Processed)
+		if job.Kind == JobKindYoutube || job.Kind == JobKindDirect || job.Input.Upload != "" {
+			// Downloads and conversions start over, so a partial copy is
+			// useless
+			os.Remove(job.Input.Original)
+		}
+
+		if job.Attempts >= maxJobAttempts {
+			log.Printf("Job %s was interrupted after %d attempts, marking it failed", job.ID, job.Attempts)
+			r.update(job, func(j *Job) {
+				j.Status = JobFailed
+				j.Error = fmt.Sprintf("interrupted by a restart after %d attempts", j.Attempts)
+				j.Reason = "interrupted"
+			})
+			continue
+		}
+
+		log.Printf("Re-queueing job %s interrupted during %q (attempt %d/%d)", job.ID, job.Stage, job.Attempts, maxJobAttempts)
+		r.update(job, func(j *Job) {
+			j.Status = JobQueued
+			j.Stage = ""
+		})
+		requeued = append(requeued, job)
+	}
+	return requeued, nil
+}
+
+// runRecoveredJobs processes re-queued jobs one at a time in the background.
+func (r *jobRegistry) runRecoveredJobs(requeued []*Job) {
+	for _, job := range requeued {
+		if _, err := r.runQueued(job); err != nil {
+			log.Printf("Recovered job %s failed: %v", job.ID, err)
+		}
+	}
+}
+
+// commandContext builds a command that is killed along with all of its
+// children when ctx is done. Spleeter in particular spawns worker processes
+// that would otherwise outlive it.
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	configureProcessGroup(cmd)
+	cmd.WaitDelay = commandWaitDelay
+	return cmd
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// respondProcessingError reports a failed job, distinguishing cancellation,
+// timeouts and classified download failures from other failures. Every
+// response has a machine-readable "code" alongside the message.
+func respondProcessingError(c *gin.Context, err error) {
+	if errors.Is(err, context.Canceled) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Processing was cancelled", "code": "cancelled"})
+		return
+	}
+
+	message := "Failed to process audio"
+	var jerr *jobError
+	if errors.As(err, &jerr) {
+		message = jerr.Message
+	}
+
+	var serr *stageError
+	if errors.As(err, &serr) && serr.TimedOut {
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": fmt.Sprintf("%s: %v", message, serr), "code": "timeout"})
+		return
+	}
+
+	var yerr *ytdlpError
+	if errors.As(err, &yerr) {
+		c.JSON(yerr.Status(), gin.H{"error": message, "code": yerr.Code})
+		return
+	}
+
+	var ierr *importError
+	if errors.As(err, &ierr) {
+		c.JSON(ierr.Status, gin.H{"error": message, "code": ierr.Code})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message, "code": "processing_failed"})
+}
+
+func (s *server) getJobs(c *gin.Context) {
+	list, err := s.jobs.getAllJobs()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+		return
+	}
+
+	// Return an empty array if the list is nil
+	if list == nil {
+		c.JSON(http.StatusOK, make([]*Job, 0))
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func (s *server) getJob(c *gin.Context) {
+	job, err := s.jobs.getJobByID(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (s *server) cancelJob(c *gin.Context) {
+	id := c.Param("id")
+	if _, err := s.jobs.getJobByID(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	if !s.jobs.cancel(id) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is not running or queued"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job cancelled successfully"})
+}
+
+// retryJob re-runs a failed or cancelled job in the background. Its attempt
+// count starts over, so a manual retry gets the full recovery budget.
+func (s *server) retryJob(c *gin.Context) {
+	job, err := s.jobs.getJobByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+
+	if job.Status != JobFailed && job.Status != JobCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only failed or cancelled jobs can be retried"})
+		return
+	}
+	if job.Kind == JobKindUpload || job.Kind == JobKindChapter {
+		original := job.Input.Original
+		if job.Input.Upload != "" {
+			original = job.Input.Upload
+		}
+		if _, err := os.Stat(original); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "The uploaded file is no longer available"})
+			return
+		}
+	}
+
+	job.Attempts = 0
+	job.Error = ""
+	job.Reason = ""
+	job.Output = ""
+	if !s.jobs.activate(context.Background(), job) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is already running"})
+		return
+	}
+
+	snapshot := *job
+	go func() {
+		if _, err := s.jobs.execute(job); err != nil {
+			log.Printf("Retried job %s failed: %v", job.ID, err)
+		}
+	}()
+
+	c.JSON(http.StatusAccepted, snapshot)
+}
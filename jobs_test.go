@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// startTestJob registers a running job of r without processing anything, as
+// if a handler were part way through it.
+func startTestJob(t *testing.T, r *jobRegistry, id, kind string) (context.Context, *Job) {
+	job, err := r.create(id, kind, JobInput{})
+	if err != nil {
+		t.Fatalf("Failed to create test job: %v", err)
+	}
+
+	r.mu.Lock()
+	r.register(context.Background(), job)
+	r.mu.Unlock()
+	r.update(job, func(j *Job) {
+		j.Status = JobRunning
+		j.Attempts++
+	})
+
+	return job.ctx, job
+}
+
+func TestCancelJob(t *testing.T) {
+	srv := setupTestDB(t)
+
+	ctx, job := startTestJob(t, srv.jobs, "test-cancel-job", JobKindUpload)
+	os.MkdirAll(jobTempDir(job.ID), 0755)
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("DELETE", "/api/jobs/test-cancel-job", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if ctx.Err() == nil {
+		t.Error("Expected job context to be cancelled")
+	}
+
+	srv.jobs.finish(job, context.Canceled)
+
+	got, err := srv.jobs.getJobByID("test-cancel-job")
+	if err != nil {
+		t.Fatalf("Failed to retrieve job: %v", err)
+	}
+	if got.Status != JobCancelled {
+		t.Errorf("Expected status '%s', got '%s'", JobCancelled, got.Status)
+	}
+	if _, err := os.Stat(jobTempDir(job.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected job temp directory to be removed")
+	}
+}
+
+func TestCancelJobNotFound(t *testing.T) {
+	srv := setupTestDB(t)
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("DELETE", "/api/jobs/non-existent-id", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestCancelJobFinished(t *testing.T) {
+	srv := setupTestDB(t)
+
+	_, job := startTestJob(t, srv.jobs, "test-finished-job", JobKindYoutube)
+	srv.jobs.finish(job, nil)
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("DELETE", "/api/jobs/test-finished-job", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	srv := setupTestDB(t)
+
+	ctx, job := startTestJob(t, srv.jobs, "test-get-job", JobKindUpload)
+	defer srv.jobs.finish(job, nil)
+	setStage(ctx, StageSeparating)
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("GET", "/api/jobs/test-get-job", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var got Job
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if got.Status != JobRunning {
+		t.Errorf("Expected status '%s', got '%s'", JobRunning, got.Status)
+	}
+	if got.Stage != StageSeparating {
+		t.Errorf("Expected stage '%s', got '%s'", StageSeparating, got.Stage)
+	}
+}
+
+func TestRecoverJobs(t *testing.T) {
+	srv := setupTestDB(t)
+
+	tempDir := t.TempDir()
+	original := filepath.Join(tempDir, "original.mp3")
+	partial := filepath.Join(tempDir, "partial.mp3")
+	os.WriteFile(original, []byte("original"), 0644)
+	os.WriteFile(partial, []byte("partial"), 0644)
+
+	// Interrupted once: should be re-queued with its upload kept
+	retry, _ := srv.jobs.create("test-recover-retry", JobKindUpload, JobInput{Original: original, Processed: partial})
+	srv.jobs.update(retry, func(j *Job) {
+		j.Status = JobRunning
+		j.Stage = StageSeparating
+		j.Attempts = 1
+	})
+	os.MkdirAll(jobTempDir(retry.ID), 0755)
+
+	// Out of attempts: should be marked failed
+	exhausted, _ := srv.jobs.create("test-recover-exhausted", JobKindYoutube, JobInput{URL: "https://youtu.be/x"})
+	srv.jobs.update(exhausted, func(j *Job) {
+		j.Status = JobRunning
+		j.Attempts = maxJobAttempts
+	})
+
+	requeued, err := srv.jobs.recoverJobs()
+	if err != nil {
+		t.Fatalf("recoverJobs failed: %v", err)
+	}
+
+	if len(requeued) != 1 || requeued[0].ID != retry.ID {
+		t.Fatalf("Expected only '%s' to be re-queued, got %v", retry.ID, requeued)
+	}
+	if got, _ := srv.jobs.getJobByID(retry.ID); got.Status != JobQueued {
+		t.Errorf("Expected status '%s', got '%s'", JobQueued, got.Status)
+	}
+	if got, _ := srv.jobs.getJobByID(exhausted.ID); got.Status != JobFailed || got.Reason != "interrupted" {
+		t.Errorf("Expected failed/interrupted, got '%s'/'%s'", got.Status, got.Reason)
+	}
+
+	if _, err := os.Stat(original); err != nil {
+		t.Errorf("Expected the uploaded original to be kept for the retry")
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("Expected partial processed file to be removed")
+	}
+	if _, err := os.Stat(jobTempDir(retry.ID)); !os.IsNotExist(err) {
+		t.Errorf("Expected job temp directory to be removed")
+	}
+}
+
+func TestRecoverJobsOfThisInstanceOnly(t *testing.T) {
+	srv := setupTestDB(t)
+	old := config
+	t.Cleanup(func() { config = old })
+
+	// Jobs created before jobs had an instance belong to every instance
+	unowned, _ := srv.jobs.create("test-recover-unowned", JobKindUpload, JobInput{})
+	for _, instance := range []string{"first", "second"} {
+		cfg := *config
+		cfg.Server.Instance = instance
+		config = &cfg
+		srv.jobs.create("test-recover-"+instance, JobKindUpload, JobInput{})
+	}
+
+	requeued, err := srv.jobs.recoverJobs()
+	if err != nil {
+		t.Fatalf("recoverJobs failed: %v", err)
+	}
+	var ids []string
+	for _, job := range requeued {
+		ids = append(ids, job.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{unowned.ID, "test-recover-second"}) {
+		t.Errorf("Expected the unowned job and that of the second instance, got %v", ids)
+	}
+}
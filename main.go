@@ -1,14 +1,16 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,6 +22,7 @@ import (
 
 const Version = "0.3.0"
 
+// Sent with downloads unless import.user_agents is configured
 var userAgents = []string{
 	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
 	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
@@ -32,7 +35,8 @@ var userAgents = []string{
 }
 
 func getRandomUserAgent() string {
-	return userAgents[rand.Intn(len(userAgents))]
+	agents := config.Import.UserAgents
+	return agents[rand.Intn(len(agents))]
 }
 
 func copyFile(src, dst string) error {
@@ -57,22 +61,88 @@ func copyFile(src, dst string) error {
 }
 
 type Song struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	Original  string    `json:"original"`
-	Processed string    `json:"processed"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Original  string `json:"original"`
+	Processed string `json:"processed"`
+	// SHA-256 of the uploaded file, shared by songs with the same content
+	ContentHash string `json:"content_hash,omitempty"`
+	// The job that split this song from a longer video
+	ParentID  string    `json:"parent_id,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
+	// Where the song was downloaded from, if it was not uploaded
+	Source *Source `json:"source,omitempty"`
+	// Named positions in the song, from the video's chapters
+	Markers []Chapter `json:"markers,omitempty"`
 }
 
-var db *sql.DB
+// server handles the API requests for one library.
+type server struct {
+	db    *database
+	songs SongStore
+	jobs  *jobRegistry
+}
+
+// newServer returns a server for the library of songs, keeping its jobs,
+// import batches, render cache and uploads in db.
+func newServer(db *database, songs SongStore) *server {
+	return &server{db: db, songs: songs, jobs: newJobRegistry(db, songs)}
+}
 
-func main() {
+// routes registers the API handlers on api.
+func (s *server) routes(api *gin.RouterGroup) {
+	api.POST("/upload", s.uploadSong)
+	tus := api.Group("/uploads", tusHeaders)
+	tus.OPTIONS("", tusOptions)
+	tus.POST("", s.createResumableUpload)
+	tus.HEAD("/:id", s.headResumableUpload)
+	tus.GET("/:id", s.getResumableUploadStatus)
+	tus.PATCH("/:id", s.patchResumableUpload)
+	tus.DELETE("/:id", s.terminateResumableUpload)
+	api.POST("/youtube", s.downloadYoutube)
+	api.POST("/import", s.importURL)
+	api.POST("/youtube/playlist", s.importPlaylist)
+	api.GET("/batches/:id", s.getImportBatch)
+	api.GET("/songs", s.getSongs)
+	api.GET("/download/:id", s.downloadSong)
+	api.GET("/download/:id/original", s.downloadOriginalSong)
+	api.DELETE("/songs/:id", s.deleteSong)
+	api.PUT("/songs/:id", s.renameSong)
+	api.GET("/version", getVersion)
+	api.GET("/jobs", s.getJobs)
+	api.GET("/jobs/:id", s.getJob)
+	api.DELETE("/jobs/:id", s.cancelJob)
+	api.POST("/jobs/:id/retry", s.retryJob)
+	admin := api.Group("/admin", requireAdmin)
+	admin.GET("/cache", s.getRenderCache)
+	admin.DELETE("/cache", s.purgeRenderCache)
+	admin.DELETE("/cache/:key", s.deleteRenderCacheEntry)
+	admin.GET("/cookies", getCookies)
+	admin.PUT("/cookies", uploadCookies)
+	admin.DELETE("/cookies", deleteCookies)
+	admin.GET("/config", getConfig)
+}
+
+// serve runs the web server with the configuration in use until it fails.
+func serve() error {
 	// Initialize database
-	initDB()
+	db := initDB()
 	defer db.Close()
+	srv := newServer(db, newSQLSongStore(db))
 
-	// Clean up any leftover temporary files on startup
+	// Pick up jobs interrupted by a restart, then clean up any leftover
+	// temporary files before they run again
+	requeued, err := srv.jobs.recoverJobs()
+	if err != nil {
+		log.Printf("Failed to recover interrupted jobs: %v", err)
+	}
 	cleanupTempFiles()
+	srv.startUploadJanitor()
+
+	// Start the separation worker so the model is loaded before the first song
+	startSeparatorWorker()
+	go srv.jobs.runRecoveredJobs(requeued)
+	startInboxWatcher(srv.jobs)
 
 	r := gin.Default()
 
@@ -81,127 +151,94 @@ func main() {
 	r.StaticFile("/", "./web/build/index.html")
 	r.StaticFile("/favicon.ico", "./web/build/favicon.ico")
 
-	// Create uploads directory
-	os.MkdirAll("uploads", 0755)
-	os.MkdirAll("processed", 0755)
-	os.MkdirAll("temp", 0755)
+	makeDataDirs()
 
 	// API routes
-	api := r.Group("/api")
-	{
-		api.POST("/upload", uploadSong)
-		api.POST("/youtube", downloadYoutube)
-		api.GET("/songs", getSongs)
-		api.GET("/download/:id", downloadSong)
-		api.GET("/download/:id/original", downloadOriginalSong)
-		api.DELETE("/songs/:id", deleteSong)
-		api.PUT("/songs/:id", renameSong)
-		api.GET("/version", getVersion)
-	}
-
-	r.Run(":8080")
+	srv.routes(r.Group("/api"))
+
+	return r.Run(config.Server.Addr)
+}
+
+// makeDataDirs creates the directories songs are stored and processed in,
+// and the render cache.
+func makeDataDirs() {
+	os.MkdirAll(config.Paths.Uploads, 0755)
+	os.MkdirAll(config.Paths.Processed, 0755)
+	os.MkdirAll(config.Paths.Temp, 0755)
+	os.MkdirAll(config.Cache.Dir, 0755)
 }
 
-func initDB() {
-	var err error
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = "./data/songs.db"
+// initDB opens the database and applies the migrations it is missing.
+func initDB() *database {
+	db := openDB()
+	if err := db.runMigrations(); err != nil {
+		log.Fatal("Failed to migrate database:", err)
 	}
-	db, err = sql.Open("sqlite3", dbPath)
+	return db
+}
+
+// openDB opens the database at the configured path without changing it.
+func openDB() *database {
+	if config.Database.DSN != "" {
+		conn, err := sql.Open("postgres", config.Database.DSN)
+		if err != nil {
+			log.Fatal("Failed to open database:", err)
+		}
+		return &database{DB: conn, driver: "postgres"}
+	}
+
+	dbPath := config.Paths.Database
+	conn, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
 	}
 
 	// Create data directory
 	os.MkdirAll(filepath.Dir(dbPath), 0755)
-
-	// Create songs table
-	createTable := `
-	CREATE TABLE IF NOT EXISTS songs (
-		id TEXT PRIMARY KEY,
-		name TEXT NOT NULL,
-		original_path TEXT NOT NULL,
-		processed_path TEXT NOT NULL,
-		created_at DATETIME NOT NULL
-	);`
-
-	_, err = db.Exec(createTable)
-	if err != nil {
-		log.Fatal("Failed to create table:", err)
-	}
+	return &database{DB: conn, driver: "sqlite3"}
 }
 
-func saveSong(song *Song) error {
-	query := `INSERT INTO songs (id, name, original_path, processed_path, created_at) VALUES (?, ?, ?, ?, ?)`
-	_, err := db.Exec(query, song.ID, song.Name, song.Original, song.Processed, song.CreatedAt)
-	return err
-}
-
-func getSongByID(id string) (*Song, error) {
-	query := `SELECT id, name, original_path, processed_path, created_at FROM songs WHERE id = ?`
-	row := db.QueryRow(query, id)
-
-	var song Song
-	err := row.Scan(&song.ID, &song.Name, &song.Original, &song.Processed, &song.CreatedAt)
-	if err != nil {
-		return nil, err
+func (s *server) uploadSong(c *gin.Context) {
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["file"]) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
 	}
-	return &song, nil
-}
+	headers := form.File["file"]
 
-func getAllSongs() ([]*Song, error) {
-	query := `SELECT id, name, original_path, processed_path, created_at FROM songs ORDER BY created_at DESC`
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
+	duplicate := c.DefaultPostForm("duplicate", DuplicateExisting)
+	if duplicate != DuplicateExisting && duplicate != DuplicateNew {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate must be \"existing\" or \"new\""})
+		return
 	}
-	defer rows.Close()
 
-	var songs []*Song
-	for rows.Next() {
-		var song Song
-		err := rows.Scan(&song.ID, &song.Name, &song.Original, &song.Processed, &song.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		songs = append(songs, &song)
+	header := headers[0]
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if len(headers) == 1 && !isArchive(header.Filename) && !audioExtensions[ext] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Only audio files are supported"})
+		return
 	}
-	return songs, nil
-}
-
-func deleteSongFromDB(id string) error {
-	query := `DELETE FROM songs WHERE id = ?`
-	_, err := db.Exec(query, id)
-	return err
-}
 
-func updateSongName(id, name string) error {
-	query := `UPDATE songs SET name = ? WHERE id = ?`
-	_, err := db.Exec(query, name, id)
-	return err
-}
+	// Several files, an archive of them or a file that has to be converted
+	// to MP3 are processed in the background
+	if len(headers) > 1 || isArchive(header.Filename) || ext != ".mp3" {
+		s.uploadFiles(c, headers, duplicate)
+		return
+	}
 
-func uploadSong(c *gin.Context) {
-	file, header, err := c.Request.FormFile("file")
+	file, err := header.Open()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
 	defer file.Close()
 
-	// Validate file type
-	if !strings.HasSuffix(strings.ToLower(header.Filename), ".mp3") {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Only MP3 files are supported"})
-		return
-	}
-
 	// Generate unique ID
 	id := uuid.New().String()
-	originalPath := filepath.Join("uploads", id+".mp3")
-	processedPath := filepath.Join("processed", id+".mp3")
+	originalPath := filepath.Join(config.Paths.Uploads, id+".mp3")
+	processedPath := filepath.Join(config.Paths.Processed, id+".mp3")
 
-	// Save uploaded file
+	// Save uploaded file, hashing it on the way
 	dst, err := os.Create(originalPath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
@@ -209,46 +246,95 @@ func uploadSong(c *gin.Context) {
 	}
 	defer dst.Close()
 
-	_, err = io.Copy(dst, file)
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), file)
 	if err != nil {
 		// Clean up partial file if copy fails
 		os.Remove(originalPath)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
 	}
+	contentHash := hex.EncodeToString(hash.Sum(nil))
 
-	// Process the file to remove drums
-	err = removeDrums(originalPath, processedPath)
+	// The same file has been processed before, so skip Spleeter
+	song, err := findDuplicate(c.Request.Context(), s.songs, contentHash, duplicate, header.Filename)
+	if err != nil {
+		os.Remove(originalPath)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save song metadata"})
+		return
+	}
+	if song != nil {
+		os.Remove(originalPath)
+		c.JSON(http.StatusOK, song)
+		return
+	}
+
+	job, err := s.jobs.create(id, JobKindUpload, JobInput{
+		Filename:    header.Filename,
+		Original:    originalPath,
+		Processed:   processedPath,
+		ContentHash: contentHash,
+	})
 	if err != nil {
-		// Clean up original file if processing fails
 		os.Remove(originalPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process audio"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job"})
 		return
 	}
 
+	song, err = s.jobs.run(c.Request.Context(), job)
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, song)
+}
+
+// processUpload removes the drums from an uploaded file and adds it to the
+// library.
+func (r *jobRegistry) processUpload(ctx context.Context, job *Job) (*Song, error) {
+	in := job.Input
+
+	if in.Upload != "" {
+		if err := convertToMP3(ctx, in.Upload, in.Original); err != nil {
+			return nil, &jobError{"Failed to read the uploaded audio", err}
+		}
+		os.Remove(in.Upload)
+		r.update(job, func(j *Job) { j.Input.Upload = "" })
+	}
+
+	// Process the file to remove drums. The upload is kept on failure so
+	// that the job can be retried.
+	err := r.renders.renderDrumless(ctx, in.Original, in.Processed, jobTempDir(job.ID))
+	if err != nil {
+		os.Remove(in.Processed)
+		return nil, &jobError{"Failed to process audio", err}
+	}
+
 	// Store song metadata
 	song := &Song{
-		ID:        id,
-		Name:      strings.TrimSuffix(header.Filename, ".mp3"),
-		Original:  originalPath,
-		Processed: processedPath,
-		CreatedAt: time.Now(),
+		ID:          job.ID,
+		Name:        strings.TrimSuffix(in.Filename, ".mp3"),
+		Original:    in.Original,
+		Processed:   in.Processed,
+		ContentHash: in.ContentHash,
+		ParentID:    in.ParentID,
+		CreatedAt:   time.Now(),
 	}
 
-	err = saveSong(song)
+	err = r.songs.SaveSong(ctx, song)
 	if err != nil {
 		// Clean up files if database save fails
-		os.Remove(originalPath)
-		os.Remove(processedPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save song metadata"})
-		return
+		os.Remove(in.Original)
+		os.Remove(in.Processed)
+		return nil, &jobError{"Failed to save song metadata", err}
 	}
 
-	c.JSON(http.StatusOK, song)
+	return song, nil
 }
 
-func getSongs(c *gin.Context) {
-	songList, err := getAllSongs()
+func (s *server) getSongs(c *gin.Context) {
+	songList, err := s.songs.ListSongs(c.Request.Context())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch songs"})
 		return
@@ -263,9 +349,9 @@ func getSongs(c *gin.Context) {
 	c.JSON(http.StatusOK, songList)
 }
 
-func downloadSong(c *gin.Context) {
+func (s *server) downloadSong(c *gin.Context) {
 	id := c.Param("id")
-	song, err := getSongByID(id)
+	song, err := s.songs.GetSong(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
 		return
@@ -275,9 +361,9 @@ func downloadSong(c *gin.Context) {
 	c.File(song.Processed)
 }
 
-func downloadOriginalSong(c *gin.Context) {
+func (s *server) downloadOriginalSong(c *gin.Context) {
 	id := c.Param("id")
-	song, err := getSongByID(id)
+	song, err := s.songs.GetSong(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
 		return
@@ -287,20 +373,17 @@ func downloadOriginalSong(c *gin.Context) {
 	c.File(song.Original)
 }
 
-func deleteSong(c *gin.Context) {
+func (s *server) deleteSong(c *gin.Context) {
 	id := c.Param("id")
-	song, err := getSongByID(id)
+	song, err := s.songs.GetSong(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
 		return
 	}
 
-	// Delete files
-	os.Remove(song.Original)
-	os.Remove(song.Processed)
-
-	// Remove from database
-	err = deleteSongFromDB(id)
+	// Remove from database, then delete the files unless another song
+	// still shares them
+	err = deleteSongAndFiles(c.Request.Context(), s.songs, song)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete song from database"})
 		return
@@ -309,9 +392,9 @@ func deleteSong(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Song deleted successfully"})
 }
 
-func renameSong(c *gin.Context) {
+func (s *server) renameSong(c *gin.Context) {
 	id := c.Param("id")
-	song, err := getSongByID(id)
+	song, err := s.songs.GetSong(c.Request.Context(), id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Song not found"})
 		return
@@ -325,7 +408,7 @@ func renameSong(c *gin.Context) {
 		return
 	}
 
-	err = updateSongName(id, req.Name)
+	err = s.songs.RenameSong(c.Request.Context(), id, req.Name)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update song name"})
 		return
@@ -335,48 +418,68 @@ func renameSong(c *gin.Context) {
 	c.JSON(http.StatusOK, song)
 }
 
-func removeDrums(inputPath, outputPath string) error {
-	// Create temporary directory for Spleeter output
-	tempDir := filepath.Join("temp", uuid.New().String())
+// The stems mixed back together for a drumless render
+var drumlessStems = []string{"vocals", "bass", "piano", "other"}
+
+// removeDrums separates inputPath with Spleeter and writes a drumless mix to
+// outputPath. Intermediate stems are written below workDir, which the caller
+// owns and cleans up. Every child process is killed if ctx is cancelled.
+func removeDrums(ctx context.Context, inputPath, outputPath, workDir string) error {
+	return mixStems(ctx, inputPath, outputPath, workDir, drumlessStems)
+}
+
+// mixStems separates inputPath with Spleeter and writes a mix of the given
+// stems to outputPath, like removeDrums.
+func mixStems(ctx context.Context, inputPath, outputPath, workDir string, stems []string) error {
+	tempDir := filepath.Join(workDir, "spleeter")
 	defer os.RemoveAll(tempDir)
 
-	// Use Spleeter's highest fidelity 5-stem model for better separation
-	cmd := exec.Command("spleeter", "separate",
-		"-p", "spleeter:5stems-16kHz",
-		"-o", tempDir,
-		inputPath)
-	output, err := cmd.CombinedOutput()
+	// Stage time limits scale with the length of the song
+	duration, err := probeDuration(ctx, inputPath)
 	if err != nil {
-		log.Printf("Spleeter separation failed: %v\nOutput: %s", err, string(output))
-		return fmt.Errorf("spleeter separation failed: %w", err)
+		log.Printf("Failed to read duration of %s, using base time limits: %v", inputPath, err)
 	}
 
-	// Get the base filename without extension
-	baseName := strings.TrimSuffix(filepath.Base(inputPath), ".mp3")
+	// Use Spleeter's highest fidelity 5-stem model for better separation
+	err = retryStage(ctx, StageSeparating, func(attempt int) error {
+		// Start each attempt from a clean output directory
+		os.RemoveAll(tempDir)
+		if chunking.enabled(duration) {
+			return separateChunked(ctx, inputPath, tempDir, duration)
+		}
+		return separateStems(ctx, inputPath, tempDir, duration)
+	})
+	if err != nil {
+		log.Printf("Spleeter separation failed: %v", err)
+		return err
+	}
 
-	// 5-stem model provides: vocals, drums, bass, piano, other
-	vocalsPath := filepath.Join(tempDir, baseName, "vocals.wav")
-	bassPath := filepath.Join(tempDir, baseName, "bass.wav")
-	pianoPath := filepath.Join(tempDir, baseName, "piano.wav")
-	otherPath := filepath.Join(tempDir, baseName, "other.wav")
+	baseName := stemsDirName(inputPath)
 
-	// Use high-quality FFmpeg settings for mixing and encoding
-	cmd = exec.Command("ffmpeg",
-		"-i", vocalsPath,
-		"-i", bassPath,
-		"-i", pianoPath,
-		"-i", otherPath,
-		"-filter_complex", "[0:a][1:a][2:a][3:a]amix=inputs=4:duration=longest:normalize=0:weights=1 1 1 1",
-		"-c:a", "libmp3lame",
-		"-q:a", "0", // Highest quality VBR
-		"-ar", "44100", // Standard sample rate
-		"-ac", "2", // Stereo
-		"-y", outputPath)
+	var args []string
+	for _, stem := range stems {
+		args = append(args, "-i", filepath.Join(tempDir, baseName, stem+".wav"))
+	}
+	if len(stems) > 1 {
+		var inputs, weights []string
+		for i := range stems {
+			inputs = append(inputs, fmt.Sprintf("[%d:a]", i))
+			weights = append(weights, "1")
+		}
+		args = append(args, "-filter_complex", fmt.Sprintf("%samix=inputs=%d:duration=longest:normalize=0:weights=%s",
+			strings.Join(inputs, ""), len(stems), strings.Join(weights, " ")))
+	}
 
-	output, err = cmd.CombinedOutput()
+	// Encode with the configured quality, by default the highest VBR
+	// quality at 44.1 kHz stereo
+	args = append(args, config.Encoding.args()...)
+	args = append(args, "-y", outputPath)
+	err = retryStage(ctx, StageMixing, func(attempt int) error {
+		return runStage(ctx, StageMixing, stageLimits[StageMixing].forDuration(duration), "ffmpeg", args...)
+	})
 	if err != nil {
-		log.Printf("FFmpeg mixing failed: %v\nOutput: %s", err, string(output))
-		return fmt.Errorf("audio mixing failed: %w", err)
+		log.Printf("FFmpeg mixing failed: %v", err)
+		return err
 	}
 
 	return nil
@@ -384,7 +487,7 @@ func removeDrums(inputPath, outputPath string) error {
 
 func cleanupTempFiles() {
 	// Clean up any leftover temporary directories
-	tempDir := "temp"
+	tempDir := config.Paths.Temp
 	if _, err := os.Stat(tempDir); os.IsNotExist(err) {
 		return
 	}
@@ -407,17 +510,25 @@ func cleanupTempFiles() {
 	log.Println("Cleaned up temporary files on startup")
 }
 
-func downloadYoutubeWithRetry(url string, tempDir string, tempAudioPath string, maxRetries int) error {
-	var lastError error
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		log.Printf("YouTube download attempt %d/%d for URL: %s", attempt, maxRetries, url)
+// downloadYoutubeWithRetry downloads the audio of url into tempDir, retrying
+// transient failures according to the download stage's retry policy. It
+// returns the video's metadata, printed by yt-dlp during the download, or nil
+// if it could not be read. extraArgs are passed to yt-dlp before the URL.
+func downloadYoutubeWithRetry(ctx context.Context, url string, tempDir string, tempAudioPath string, extraArgs ...string) (*videoInfo, error) {
+	var info *videoInfo
+	err := retryStage(ctx, StageDownloading, func(attempt int) error {
+		if err := waitForImportRequest(ctx); err != nil {
+			return err
+		}
+		log.Printf("YouTube download attempt %d/%d for URL: %s", attempt, stageRetryPolicies[StageDownloading].MaxAttempts, url)
 
 		// Get random user agent for this attempt
 		userAgent := getRandomUserAgent()
 
 		// Build yt-dlp command with anti-detection measures
-		cmd := exec.Command("yt-dlp",
+		args := []string{
+			"--dump-json",
+			"--no-simulate",
 			"--extract-audio",
 			"--audio-format", "mp3",
 			"--audio-quality", "192K",
@@ -433,148 +544,235 @@ func downloadYoutubeWithRetry(url string, tempDir string, tempAudioPath string,
 			"--sleep-interval", "1",
 			"--max-sleep-interval", "5",
 			"--verbose",
-			url)
-
-		// Capture both stdout and stderr
-		output, err := cmd.CombinedOutput()
+		}
+		args = append(args, ytdlpNetworkArgs()...)
+		args = append(append(args, extraArgs...), url)
 
-		if err == nil {
-			log.Printf("YouTube download successful on attempt %d", attempt)
-			return nil
+		output, err := runStageOutput(ctx, StageDownloading, stageLimits[StageDownloading].Base, "yt-dlp", args...)
+		if err != nil {
+			return err
 		}
 
-		lastError = fmt.Errorf("attempt %d failed: %v, output: %s", attempt, err, string(output))
-		log.Printf("YouTube download attempt %d failed: %v", attempt, lastError)
+		log.Printf("YouTube download successful on attempt %d", attempt)
+		info, err = parseVideoInfo(output)
+		if err != nil {
+			log.Printf("Failed to read metadata for %s: %v", url, err)
+		}
+		return nil
+	})
+	return info, err
+}
 
-		// Don't sleep after the last attempt
-		if attempt < maxRetries {
-			// Exponential backoff with jitter
-			sleepTime := time.Duration(attempt*attempt) * time.Second
-			jitter := time.Duration(rand.Intn(1000)) * time.Millisecond
-			totalSleep := sleepTime + jitter
+func (s *server) downloadYoutube(c *gin.Context) {
+	var req downloadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
 
-			log.Printf("Waiting %v before retry...", totalSleep)
-			time.Sleep(totalSleep)
-		}
+	if req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "YouTube URL is required"})
+		return
+	}
+	if _, ok := checkImportURL(c, req.URL); !ok {
+		return
 	}
 
-	return fmt.Errorf("all %d attempts failed, last error: %v", maxRetries, lastError)
+	s.startDownload(c, JobKindYoutube, &req)
 }
 
-func downloadYoutube(c *gin.Context) {
-	var req struct {
-		URL string `json:"url"`
+// downloadRequest is the body of the endpoints that download a URL.
+type downloadRequest struct {
+	URL string `json:"url"`
+	// Optional section of the video to download, e.g. "12:30"
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// ChaptersMarkers (default) or ChaptersSplit
+	Chapters string `json:"chapters"`
+	// Whose cookies to use for restricted videos
+	User string `json:"user"`
+}
+
+// startDownload validates the options of req, runs a job of kind for it and
+// responds with the new song.
+func (s *server) startDownload(c *gin.Context, kind string, req *downloadRequest) {
+	start, end, err := parseSection(req.Start, req.End)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid time range: %v", err)})
+		return
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	if req.Chapters == "" {
+		req.Chapters = ChaptersMarkers
+	}
+	if req.Chapters != ChaptersMarkers && req.Chapters != ChaptersSplit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chapters must be \"markers\" or \"split\""})
 		return
 	}
 
-	if req.URL == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "YouTube URL is required"})
+	if req.User != "" && !cookieUserPattern.MatchString(req.User) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user name"})
+		return
+	}
+	if req.User != "" && !adminAuthorized(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Downloading with the cookies of a user requires the admin token"})
 		return
 	}
 
 	// Generate unique ID for this download
 	id := uuid.New().String()
-	tempDir := filepath.Join("temp", id)
+	job, err := s.jobs.create(id, kind, JobInput{
+		URL:       req.URL,
+		Start:     start,
+		End:       end,
+		Chapters:  req.Chapters,
+		User:      req.User,
+		Original:  filepath.Join(config.Paths.Uploads, id+".mp3"),
+		Processed: filepath.Join(config.Paths.Processed, id+".mp3"),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job"})
+		return
+	}
+
+	song, err := s.jobs.run(c.Request.Context(), job)
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+
+	// The video was split into chapters, which are processed in the
+	// background as a batch
+	if song == nil {
+		batch, err := s.jobs.getImportBatchByID(job.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch batch"})
+			return
+		}
+		c.JSON(http.StatusAccepted, batch)
+		return
+	}
+
+	c.JSON(http.StatusOK, song)
+}
+
+// processYoutube downloads the audio of a YouTube video, removes the drums
+// and adds it to the library. With ChaptersSplit, a video with chapters is
+// instead cut into one child job per chapter and no song is returned.
+func (r *jobRegistry) processYoutube(ctx context.Context, job *Job) (*Song, error) {
+	in := job.Input
+	tempDir := jobTempDir(job.ID)
 	tempAudioPath := filepath.Join(tempDir, "%(title)s.%(ext)s")
-	originalPath := filepath.Join("uploads", id+".mp3")
-	processedPath := filepath.Join("processed", id+".mp3")
 
-	// Create temp directory for this download
+	// Create temp directory for this download; the job removes it when finished
 	err := os.MkdirAll(tempDir, 0755)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temp directory"})
-		return
+		return nil, &jobError{"Failed to create temp directory", err}
 	}
-	defer os.RemoveAll(tempDir)
+
+	args := sectionArgs(in.Start, in.End)
+	cookies, err := cookieArgs(in.User, tempDir)
+	if err != nil {
+		return nil, &jobError{"Failed to read YouTube cookies", err}
+	}
+	args = append(args, cookies...)
 
 	// Download with retry logic
-	err = downloadYoutubeWithRetry(req.URL, tempDir, tempAudioPath, 3)
+	info, err := downloadYoutubeWithRetry(ctx, in.URL, tempDir, tempAudioPath, args...)
 	if err != nil {
 		log.Printf("YouTube download failed after all retries: %v", err)
-
-		// Provide more specific error messages
-		errorMsg := "Failed to download from YouTube"
-		if strings.Contains(err.Error(), "network") || strings.Contains(err.Error(), "connection") {
-			errorMsg = "Network error: Unable to connect to YouTube"
-		} else if strings.Contains(err.Error(), "permission") || strings.Contains(err.Error(), "forbidden") {
-			errorMsg = "Permission error: Video may be private or restricted"
-		} else if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "404") {
-			errorMsg = "Video not found: Please check the URL"
-		} else if strings.Contains(err.Error(), "age") || strings.Contains(err.Error(), "login") {
-			errorMsg = "Video is age-restricted or requires login"
+		jerr := downloadJobError(err)
+		if hint := cookieHint(jerr.Err, in.User); hint != "" {
+			jerr.Message += ". " + hint
 		}
-
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorMsg})
-		return
+		return nil, jerr
 	}
 
-	// Find the downloaded file in the temp directory
+	// Find the downloaded file in the temp directory, next to the cookies
 	files, err := os.ReadDir(tempDir)
-	if err != nil || len(files) == 0 {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Downloaded file not found"})
-		return
+	var downloadedFile string
+	for _, f := range files {
+		if f.Name() != jobCookieFile {
+			downloadedFile = filepath.Join(tempDir, f.Name())
+			break
+		}
+	}
+	if err == nil && downloadedFile == "" {
+		err = fmt.Errorf("no file in %s", tempDir)
+	}
+	if err != nil {
+		return nil, &jobError{"Downloaded file not found", err}
 	}
-
-	// Get the first (and should be only) file
-	downloadedFile := filepath.Join(tempDir, files[0].Name())
 
 	// Copy the downloaded file to uploads directory (handle cross-device links)
-	err = copyFile(downloadedFile, originalPath)
+	err = copyFile(downloadedFile, in.Original)
 	if err != nil {
-		log.Printf("Failed to copy file from %s to %s: %v", downloadedFile, originalPath, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process downloaded file"})
-		return
+		log.Printf("Failed to copy file from %s to %s: %v", downloadedFile, in.Original, err)
+		return nil, &jobError{"Failed to process downloaded file", err}
 	}
 
 	// Remove the temporary file after successful copy
 	os.Remove(downloadedFile)
 
+	chapters := info.chaptersInSection(in.Start, in.End)
+	if in.Chapters == ChaptersSplit && len(chapters) > 1 {
+		err := r.splitChapters(ctx, job, info, chapters)
+		os.Remove(in.Original)
+		if err != nil {
+			return nil, &jobError{"Failed to split the video into chapters", err}
+		}
+		return nil, nil
+	}
+
 	// Process the file to remove drums
-	err = removeDrums(originalPath, processedPath)
+	err = r.renders.renderDrumless(ctx, in.Original, in.Processed, tempDir)
 	if err != nil {
 		// Clean up original file if processing fails
-		os.Remove(originalPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process audio"})
-		return
+		os.Remove(in.Original)
+		os.Remove(in.Processed)
+		return nil, &jobError{"Failed to process audio", err}
 	}
 
-	// Get video title for the song name
-	titleCmd := exec.Command("yt-dlp", "--get-title", "--no-playlist", "--user-agent", getRandomUserAgent(), req.URL)
-	titleOutput, err := titleCmd.Output()
-	songName := "YouTube Video"
-	if err == nil {
-		songName = strings.TrimSpace(string(titleOutput))
-		// Clean up title for filesystem safety
-		songName = strings.ReplaceAll(songName, "/", "-")
-		songName = strings.ReplaceAll(songName, "\\", "-")
-		if len(songName) > 100 {
-			songName = songName[:100]
-		}
+	// Store song metadata, named after the video title and section
+	name := info.songName()
+	if in.Start > 0 || in.End > 0 {
+		name += " (" + sectionLabel(in.Start, in.End) + ")"
 	}
-
-	// Store song metadata
 	song := &Song{
-		ID:        id,
-		Name:      songName,
-		Original:  originalPath,
-		Processed: processedPath,
+		ID:        job.ID,
+		Name:      name,
+		Original:  in.Original,
+		Processed: in.Processed,
 		CreatedAt: time.Now(),
 	}
 
-	err = saveSong(song)
+	err = r.songs.SaveSong(ctx, song)
 	if err != nil {
 		// Clean up files if database save fails
-		os.Remove(originalPath)
-		os.Remove(processedPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save song metadata"})
-		return
+		os.Remove(in.Original)
+		os.Remove(in.Processed)
+		return nil, &jobError{"Failed to save song metadata", err}
+	}
+
+	if info != nil {
+		song.Source = info.source(in.URL)
+		song.Source.SectionStart = in.Start
+		song.Source.SectionEnd = in.End
+		if err := r.songs.SaveSource(ctx, song.ID, song.Source); err != nil {
+			log.Printf("Failed to save source of song %s: %v", song.ID, err)
+			song.Source = nil
+		}
 	}
 
-	c.JSON(http.StatusOK, song)
+	if len(chapters) > 0 {
+		if err := r.songs.SaveMarkers(ctx, song.ID, chapters); err != nil {
+			log.Printf("Failed to save markers of song %s: %v", song.ID, err)
+		} else {
+			song.Markers = chapters
+		}
+	}
+
+	return song, nil
 }
 
 func getVersion(c *gin.Context) {
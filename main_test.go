@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -14,33 +15,62 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// Setup a test router
+// Setup a test router for requests that do not reach the library
 func setupRouter() *gin.Engine {
+	return setupServerRouter(newServer(nil, nil))
+}
+
+// setupServerRouter returns a router with the API routes of s.
+func setupServerRouter(s *server) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	r := gin.Default()
-	api := r.Group("/api")
-	{
-		api.POST("/upload", uploadSong)
-		api.POST("/youtube", downloadYoutube)
-		api.GET("/songs", getSongs)
-		api.GET("/download/:id", downloadSong)
-		api.GET("/download/:id/original", downloadOriginalSong)
-		api.DELETE("/songs/:id", deleteSong)
-		api.PUT("/songs/:id", renameSong)
-		api.GET("/version", getVersion)
-	}
+	s.routes(r.Group("/api"))
 	return r
 }
 
-// Setup a temporary database for testing
-func setupTestDB(t *testing.T) {
+// Setup a temporary database for testing, closed when the test ends, and a
+// server for its library
+func setupTestDB(t *testing.T) *server {
+	useTestDataDirs(t)
+
 	// Create a temporary directory for the database
 	tempDir := t.TempDir()
 	dbPath := tempDir + "/test_songs.db"
 	
 	// Set up the database connection for the tests
-	os.Setenv("DB_PATH", dbPath)
-	initDB()
+	useTestDatabase(dbPath)
+	db := initDB()
+	t.Cleanup(func() { db.Close() })
+	return newServer(db, newSQLSongStore(db))
+}
+
+// useTestDatabase points the configuration in use at dbPath.
+func useTestDatabase(dbPath string) {
+	cfg := *config
+	cfg.Paths.Database = dbPath
+	config = &cfg
+}
+
+// useTestDataDirs points the configuration in use at data directories in a
+// temporary directory, so that tests leave the working tree alone.
+func useTestDataDirs(t *testing.T) {
+	dir := t.TempDir()
+	old := config
+	cfg := *config
+	cfg.Paths.Uploads = filepath.Join(dir, "uploads")
+	cfg.Paths.Processed = filepath.Join(dir, "processed")
+	cfg.Paths.Temp = filepath.Join(dir, "temp")
+	cfg.Cache.Dir = filepath.Join(dir, "cache", "renders")
+	config = &cfg
+	t.Cleanup(func() { config = old })
+
+	oldPartial := partialUploadsDir
+	partialUploadsDir = filepath.Join(config.Paths.Uploads, "partial")
+	t.Cleanup(func() { partialUploadsDir = oldPartial })
+
+	os.MkdirAll(config.Paths.Uploads, 0755)
+	os.MkdirAll(config.Paths.Processed, 0755)
+	os.MkdirAll(config.Paths.Temp, 0755)
 }
 
 func TestMain(m *testing.M) {
@@ -84,10 +114,9 @@ func TestGetVersion(t *testing.T) {
 
 func TestGetSongsEmpty(t *testing.T) {
 	// Setup a clean database for this test
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 
 	req, _ := http.NewRequest("GET", "/api/songs", nil)
 	w := httptest.NewRecorder()
@@ -105,16 +134,15 @@ func TestGetSongsEmpty(t *testing.T) {
 }
 
 func TestGetSongsWithData(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
 	// Add some songs
 	song1 := &Song{ID: "1", Name: "Song 1", CreatedAt: time.Now()}
 	song2 := &Song{ID: "2", Name: "Song 2", CreatedAt: time.Now().Add(-time.Hour)}
-	saveSong(song1)
-	saveSong(song2)
+	srv.songs.SaveSong(context.Background(), song1)
+	srv.songs.SaveSong(context.Background(), song2)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 	req, _ := http.NewRequest("GET", "/api/songs", nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -143,8 +171,7 @@ func TestGetSongsWithData(t *testing.T) {
 }
 
 func TestRenameSong(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
 	// First, add a song to the database to test renaming
 	song := &Song{
@@ -154,12 +181,12 @@ func TestRenameSong(t *testing.T) {
 		Processed: "processed/test.mp3",
 		CreatedAt:   time.Now(),
 	}
-	err := saveSong(song)
+	err := srv.songs.SaveSong(context.Background(), song)
 	if err != nil {
 		t.Fatalf("Failed to save test song: %v", err)
 	}
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 
 	// New name for the song
 	newName := "New Awesome Name"
@@ -176,7 +203,7 @@ func TestRenameSong(t *testing.T) {
 	}
 
 	// Verify the name was updated in the database
-	updatedSong, err := getSongByID("test-song-1")
+	updatedSong, err := srv.songs.GetSong(context.Background(), "test-song-1")
 	if err != nil {
 		t.Fatalf("Failed to retrieve updated song: %v", err)
 	}
@@ -187,10 +214,9 @@ func TestRenameSong(t *testing.T) {
 }
 
 func TestRenameSongNotFound(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 
 	newName := "New Name"
 	payload := map[string]string{"name": newName}
@@ -207,8 +233,7 @@ func TestRenameSongNotFound(t *testing.T) {
 }
 
 func TestRenameSongInvalidRequest(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
 	// Add a song to have a valid ID
 	song := &Song{
@@ -218,12 +243,12 @@ func TestRenameSongInvalidRequest(t *testing.T) {
 		Processed: "processed/test.mp3",
 		CreatedAt: time.Now(),
 	}
-	err := saveSong(song)
+	err := srv.songs.SaveSong(context.Background(), song)
 	if err != nil {
 		t.Fatalf("Failed to save test song: %v", err)
 	}
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 
 	req, _ := http.NewRequest("PUT", "/api/songs/test-song-1", bytes.NewBufferString("invalid-json"))
 	req.Header.Set("Content-Type", "application/json")
@@ -236,29 +261,28 @@ func TestRenameSongInvalidRequest(t *testing.T) {
 }
 
 func TestDeleteSong(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
 	// Add a song to delete
 	song := &Song{
 		ID:        "test-song-to-delete",
 		Name:      "To Be Deleted",
-		Original:  "uploads/to_be_deleted.mp3",
-		Processed: "processed/to_be_deleted.mp3",
+		Original:  filepath.Join(config.Paths.Uploads, "to_be_deleted.mp3"),
+		Processed: filepath.Join(config.Paths.Processed, "to_be_deleted.mp3"),
 		CreatedAt: time.Now(),
 	}
-	err := saveSong(song)
+	err := srv.songs.SaveSong(context.Background(), song)
 	if err != nil {
 		t.Fatalf("Failed to save test song for deletion: %v", err)
 	}
 
 	// Create dummy files to be deleted
-	os.MkdirAll("uploads", 0755)
-	os.MkdirAll("processed", 0755)
+	os.MkdirAll(config.Paths.Uploads, 0755)
+	os.MkdirAll(config.Paths.Processed, 0755)
 	os.Create(song.Original)
 	os.Create(song.Processed)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 
 	req, _ := http.NewRequest("DELETE", "/api/songs/test-song-to-delete", nil)
 	w := httptest.NewRecorder()
@@ -269,7 +293,7 @@ func TestDeleteSong(t *testing.T) {
 	}
 
 	// Verify the song is deleted from the database
-	_, err = getSongByID("test-song-to-delete")
+	_, err = srv.songs.GetSong(context.Background(), "test-song-to-delete")
 	if err == nil {
 		t.Error("Expected song to be deleted from DB, but it was found.")
 	}
@@ -284,10 +308,9 @@ func TestDeleteSong(t *testing.T) {
 }
 
 func TestDeleteSongNotFound(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 
 	req, _ := http.NewRequest("DELETE", "/api/songs/non-existent-id", nil)
 	w := httptest.NewRecorder()
@@ -299,8 +322,7 @@ func TestDeleteSongNotFound(t *testing.T) {
 }
 
 func TestDownloadSong(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
 	// Create a dummy processed file in a temporary directory
 	tempDir := t.TempDir()
@@ -321,9 +343,9 @@ func TestDownloadSong(t *testing.T) {
 		Processed: processedPath,
 		CreatedAt: time.Now(),
 	}
-	saveSong(song)
+	srv.songs.SaveSong(context.Background(), song)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 	req, _ := http.NewRequest("GET", "/api/download/test-song", nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -337,10 +359,9 @@ func TestDownloadSong(t *testing.T) {
 }
 
 func TestDownloadSongNotFound(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 	req, _ := http.NewRequest("GET", "/api/download/non-existent-id", nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -351,8 +372,7 @@ func TestDownloadSongNotFound(t *testing.T) {
 }
 
 func TestDownloadOriginalSong(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
 	// Create a dummy original file in a temporary directory
 	tempDir := t.TempDir()
@@ -373,9 +393,9 @@ func TestDownloadOriginalSong(t *testing.T) {
 		Processed: processedPath,
 		CreatedAt: time.Now(),
 	}
-	saveSong(song)
+	srv.songs.SaveSong(context.Background(), song)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 	req, _ := http.NewRequest("GET", "/api/download/test-song/original", nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -389,10 +409,9 @@ func TestDownloadOriginalSong(t *testing.T) {
 }
 
 func TestDownloadOriginalSongNotFound(t *testing.T) {
-	setupTestDB(t)
-	defer db.Close()
+	srv := setupTestDB(t)
 
-	router := setupRouter()
+	router := setupServerRouter(srv)
 	req, _ := http.NewRequest("GET", "/api/download/non-existent-id/original", nil)
 	w := httptest.NewRecorder()
 	router.ServeHTTP(w, req)
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// memorySongStore is a SongStore that keeps the library in memory, for
+// tests of the handlers that only need songs. It hands out copies, so
+// callers cannot change what it holds.
+type memorySongStore struct {
+	mu      sync.Mutex
+	songs   map[string]*Song
+	sources map[string]*Source
+	markers map[string][]Chapter
+}
+
+func newMemorySongStore() *memorySongStore {
+	return &memorySongStore{
+		songs:   make(map[string]*Song),
+		sources: make(map[string]*Source),
+		markers: make(map[string][]Chapter),
+	}
+}
+
+func (s *memorySongStore) SaveSong(ctx context.Context, song *Song) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.songs[song.ID]; ok {
+		return fmt.Errorf("song %s already exists", song.ID)
+	}
+	stored := *song
+	stored.Source = nil
+	stored.Markers = nil
+	s.songs[song.ID] = &stored
+	return nil
+}
+
+// withDetails returns a copy of song with its source and markers.
+func (s *memorySongStore) withDetails(song *Song) *Song {
+	result := *song
+	if src := s.sources[song.ID]; src != nil {
+		copied := *src
+		copied.Chapters = slices.Clone(src.Chapters)
+		result.Source = &copied
+	}
+	result.Markers = slices.Clone(s.markers[song.ID])
+	return &result
+}
+
+func (s *memorySongStore) GetSong(ctx context.Context, id string) (*Song, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	song, ok := s.songs[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return s.withDetails(song), nil
+}
+
+func (s *memorySongStore) GetSongByHash(ctx context.Context, hash string) (*Song, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var oldest *Song
+	for _, song := range s.songs {
+		if song.ContentHash == hash && (oldest == nil || song.CreatedAt.Before(oldest.CreatedAt)) {
+			oldest = song
+		}
+	}
+	if oldest == nil {
+		return nil, sql.ErrNoRows
+	}
+	result := *oldest
+	return &result, nil
+}
+
+func (s *memorySongStore) ListSongs(ctx context.Context) ([]*Song, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var songs []*Song
+	for _, song := range s.songs {
+		songs = append(songs, s.withDetails(song))
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		return songs[i].CreatedAt.After(songs[j].CreatedAt)
+	})
+	return songs, nil
+}
+
+func (s *memorySongStore) RenameSong(ctx context.Context, id, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Like an UPDATE, renaming a missing song is not an error
+	if song, ok := s.songs[id]; ok {
+		song.Name = name
+	}
+	return nil
+}
+
+func (s *memorySongStore) DeleteSong(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.songs, id)
+	delete(s.sources, id)
+	delete(s.markers, id)
+	return nil
+}
+
+func (s *memorySongStore) FileReferences(ctx context.Context, path string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for _, song := range s.songs {
+		if song.Original == path || song.Processed == path {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *memorySongStore) SaveSource(ctx context.Context, songID string, src *Source) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *src
+	stored.Chapters = slices.Clone(src.Chapters)
+	s.sources[songID] = &stored
+	return nil
+}
+
+func (s *memorySongStore) SaveMarkers(ctx context.Context, songID string, markers []Chapter) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(markers) == 0 {
+		delete(s.markers, songID)
+		return nil
+	}
+	s.markers[songID] = slices.Clone(markers)
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The schema is built by the numbered SQL files in migrations, applied in
+// order. A migration that has been released must not change; alter the
+// schema with a new file instead.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is one step of the schema, read from a file named
+// <version>_<name>.sql.
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// MigrationStatus tells whether a migration has been applied to the
+// database, and when.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+const createMigrationsTable = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME NOT NULL
+);`
+
+// loadMigrations returns the embedded migrations ordered by version.
+func loadMigrations() ([]migration, error) {
+	// fs.Glob sorts the names, and the versions are zero-padded
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, name := range names {
+		base := strings.TrimSuffix(path.Base(name), ".sql")
+		number, title, _ := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: the name must start with a version number", name)
+		}
+		if n := len(migrations); n > 0 && migrations[n-1].Version >= version {
+			return nil, fmt.Errorf("migration %s: version %d is used twice", name, version)
+		}
+
+		data, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: title, SQL: string(data)})
+	}
+	return migrations, nil
+}
+
+// appliedMigrations returns when each migration recorded in the database was
+// applied, by version.
+func (d *database) appliedMigrations() (map[int]time.Time, error) {
+	rows, err := d.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigrations applies the migrations the database is missing, each in a
+// transaction together with its row in schema_migrations, so that a failed
+// migration leaves the schema as it was. Databases of drummer 0.3.0 have no
+// schema_migrations table; the first migration accepts their songs table as
+// it is. Instances sharing a PostgreSQL database may start together; each
+// migration is applied by whichever gets to it first.
+func (d *database) runMigrations() error {
+	if err := d.createSchemaMigrations(); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	// Refuse to run against a schema this version does not know
+	latest := migrations[len(migrations)-1].Version
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("the database has schema version %d, newer than %d of drummer %s", version, latest, Version)
+		}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := d.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+func (d *database) createSchemaMigrations() error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := d.lockMigrations(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(d.schemaSQL(createMigrationsTable)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (d *database) applyMigration(m migration) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Another instance may have applied it while this one waited
+	if err := d.lockMigrations(tx); err != nil {
+		return err
+	}
+	var applied int
+	err = tx.QueryRow(d.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), m.Version).Scan(&applied)
+	if err != nil || applied > 0 {
+		return err
+	}
+
+	if _, err := tx.Exec(d.schemaSQL(m.SQL)); err != nil {
+		return err
+	}
+	_, err = tx.Exec(d.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+		m.Version, m.Name, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// migrationStatus lists every migration with when it was applied, without
+// changing the database.
+func (d *database) migrationStatus() ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	exists, err := d.tableExists("schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if applied, err = d.appliedMigrations(); err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.Version, Name: m.Name}
+		if appliedAt, ok := applied[m.Version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// printMigrationStatus writes one line per migration to out, followed by the
+// number of pending migrations.
+func printMigrationStatus(statuses []MigrationStatus, out io.Writer) {
+	pending := 0
+	for _, status := range statuses {
+		applied := "pending"
+		if status.AppliedAt != nil {
+			applied = "applied " + status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		} else {
+			pending++
+		}
+		fmt.Fprintf(out, "%04d  %-20s  %s\n", status.Version, status.Name, applied)
+	}
+	fmt.Fprintf(out, "%d of %d migrations pending\n", pending, len(statuses))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useV030Database copies the database of a drummer 0.3.0 install, holding
+// two songs, and points the configuration at the copy.
+func useV030Database(t *testing.T) string {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "songs.db")
+	if err := copyFile(filepath.Join("testdata", "v0.3.0.db"), dbPath); err != nil {
+		t.Fatal(err)
+	}
+	useTestDatabase(dbPath)
+	return dbPath
+}
+
+func TestMigrationsUpgradeV030Database(t *testing.T) {
+	useV030Database(t)
+
+	db := openDB()
+	statuses, err := db.migrationStatus()
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt != nil {
+			t.Errorf("Expected migration %d to be pending, applied %v", status.Version, status.AppliedAt)
+		}
+	}
+
+	db = initDB()
+	defer db.Close()
+	jobs := newJobRegistry(db, newSQLSongStore(db))
+
+	songs, err := jobs.songs.ListSongs(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to read songs from the upgraded database: %v", err)
+	}
+	if len(songs) != 2 {
+		t.Fatalf("Expected the 2 songs of the fixture, got %d", len(songs))
+	}
+	for _, song := range songs {
+		if song.ContentHash != "" || song.ParentID != "" || song.CreatedAt.IsZero() {
+			t.Errorf("Expected an old song without hash or parent, got %+v", song)
+		}
+	}
+
+	// The tables added since 0.3.0 are usable
+	batch := &ImportBatch{ID: "batch", Kind: BatchKindFolder, Title: "Gigs", CreatedAt: time.Now()}
+	if err := jobs.saveImportBatch(batch); err != nil {
+		t.Fatalf("Failed to save an import batch: %v", err)
+	}
+	if _, err := jobs.getImportBatchByID("batch"); err != nil {
+		t.Errorf("Failed to read the import batch: %v", err)
+	}
+
+	statuses, err = db.migrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("Expected migration %d to be applied", status.Version)
+		}
+	}
+
+	// Running them again changes nothing
+	if err := db.runMigrations(); err != nil {
+		t.Errorf("Expected applied migrations to be skipped, got %v", err)
+	}
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count)
+	if count != len(statuses) {
+		t.Errorf("Expected %d recorded migrations, got %d", len(statuses), count)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.Version)
+		}
+		if m.Name == "" || strings.TrimSpace(m.SQL) == "" {
+			t.Errorf("Expected migration %d to have a name and SQL", m.Version)
+		}
+	}
+}
+
+func TestFailedMigrationRollsBack(t *testing.T) {
+	srv := setupTestDB(t)
+
+	err := srv.db.applyMigration(migration{
+		Version: 99,
+		Name:    "broken",
+		SQL:     "CREATE TABLE setlists (id TEXT PRIMARY KEY); INSERT INTO missing VALUES (1);",
+	})
+	if err == nil {
+		t.Fatal("Expected the migration to fail")
+	}
+
+	var count int
+	srv.db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'setlists'").Scan(&count)
+	if count != 0 {
+		t.Error("Expected the table of the failed migration to be rolled back")
+	}
+	srv.db.QueryRow("SELECT COUNT(*) FROM schema_migrations WHERE version = 99").Scan(&count)
+	if count != 0 {
+		t.Error("Expected the failed migration not to be recorded")
+	}
+}
+
+func TestRunMigrationsRejectsNewerSchema(t *testing.T) {
+	srv := setupTestDB(t)
+
+	_, err := srv.db.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (999, 'future', ?)", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.db.runMigrations(); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("Expected a schema from a later version to be refused, got %v", err)
+	}
+}
+
+func TestMigrateStatusCommand(t *testing.T) {
+	t.Setenv("DB_PATH", useV030Database(t))
+	t.Cleanup(func() { applyConfig(configDefaults) })
+
+	var stdout, stderr bytes.Buffer
+	if code := runCommand([]string{"migrate", "status"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d: %s", code, stderr.String())
+	}
+	migrations, _ := loadMigrations()
+	pending := fmt.Sprintf("%d of %d migrations pending\n", len(migrations), len(migrations))
+	if !strings.Contains(stdout.String(), "0001  songs") || !strings.HasSuffix(stdout.String(), pending) {
+		t.Errorf("Expected every migration to be pending, got %q", stdout.String())
+	}
+
+	for _, args := range [][]string{{"migrate"}, {"migrate", "down"}} {
+		if code := runCommand(args, &stdout, &stderr); code != 2 {
+			t.Errorf("%v: Expected exit code 2, got %d", args, code)
+		}
+	}
+}
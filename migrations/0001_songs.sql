@@ -0,0 +1,9 @@
+-- The library as created by drummer 0.3.0 and earlier, which created the
+-- table at startup without recording a schema version.
+CREATE TABLE IF NOT EXISTS songs (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	original_path TEXT NOT NULL,
+	processed_path TEXT NOT NULL,
+	created_at DATETIME NOT NULL
+);
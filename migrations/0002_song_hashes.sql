@@ -0,0 +1,3 @@
+-- Detect uploads of songs already in the library.
+ALTER TABLE songs ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
+CREATE INDEX songs_content_hash ON songs (content_hash);
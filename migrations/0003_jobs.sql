@@ -0,0 +1,24 @@
+-- Processing runs as jobs, grouped into batches by the request that
+-- started them.
+CREATE TABLE jobs (
+	id TEXT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	status TEXT NOT NULL,
+	stage TEXT NOT NULL DEFAULT '',
+	error TEXT NOT NULL DEFAULT '',
+	reason TEXT NOT NULL DEFAULT '',
+	output TEXT NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	input TEXT NOT NULL,
+	batch_id TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE import_batches (
+	id TEXT PRIMARY KEY,
+	kind TEXT NOT NULL,
+	url TEXT NOT NULL DEFAULT '',
+	title TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL
+);
@@ -0,0 +1,22 @@
+-- Where downloaded songs came from, and the chapters marked in them.
+CREATE TABLE sources (
+	song_id TEXT PRIMARY KEY REFERENCES songs (id),
+	url TEXT NOT NULL,
+	video_id TEXT NOT NULL DEFAULT '',
+	uploader TEXT NOT NULL DEFAULT '',
+	upload_date TEXT NOT NULL DEFAULT '',
+	duration REAL NOT NULL DEFAULT 0,
+	thumbnail TEXT NOT NULL DEFAULT '',
+	chapters TEXT NOT NULL DEFAULT '[]',
+	section_start REAL NOT NULL DEFAULT 0,
+	section_end REAL NOT NULL DEFAULT 0
+);
+
+CREATE TABLE markers (
+	song_id TEXT NOT NULL REFERENCES songs (id),
+	position INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	start_time REAL NOT NULL,
+	end_time REAL NOT NULL,
+	PRIMARY KEY (song_id, position)
+);
@@ -0,0 +1,2 @@
+-- Songs split from the chapters of a video point to the job that split them.
+ALTER TABLE songs ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,13 @@
+-- Renders kept for reuse, keyed by the audio and the settings that made them.
+CREATE TABLE render_cache (
+	key TEXT PRIMARY KEY,
+	audio_hash TEXT NOT NULL,
+	backend TEXT NOT NULL,
+	model TEXT NOT NULL,
+	recipe TEXT NOT NULL,
+	format TEXT NOT NULL,
+	size INTEGER NOT NULL,
+	hits INTEGER NOT NULL DEFAULT 0,
+	created_at DATETIME NOT NULL,
+	last_used_at DATETIME NOT NULL
+);
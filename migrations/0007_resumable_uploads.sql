@@ -0,0 +1,12 @@
+-- Uploads sent in parts, tracking how much of each file arrived.
+CREATE TABLE resumable_uploads (
+	id TEXT PRIMARY KEY,
+	filename TEXT NOT NULL,
+	length INTEGER NOT NULL,
+	received INTEGER NOT NULL DEFAULT 0,
+	duplicate TEXT NOT NULL,
+	job_id TEXT NOT NULL DEFAULT '',
+	song_id TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL,
+	expires_at DATETIME NOT NULL
+);
@@ -0,0 +1,3 @@
+-- The instance that created each job, so that instances sharing a database
+-- only recover their own jobs after a restart.
+ALTER TABLE jobs ADD COLUMN instance TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A download waiting for its turn under the import rate limit.
+const StageDownloadQueued = "download_queued"
+
+// Outbound traffic of imports: yt-dlp runs and direct downloads.
+var (
+	// Proxy for all import traffic, e.g. socks5://host:1080; empty for none
+	importProxy string
+	// Bytes per second each download may use; 0 for no limit
+	downloadRateLimit int64
+	// Spaces out the requests of imports; nil for no limit
+	importRequests *requestLimiter
+)
+
+// loadOutboundSettings applies the configured proxy, request rate and
+// download rate limit. The proxy URL has been validated.
+func loadOutboundSettings() {
+	importProxy = config.Import.Proxy
+	importClient.Transport = nil
+	if proxy, err := url.Parse(importProxy); importProxy != "" && err == nil {
+		// Keep the timeouts, connection limits and HTTP/2 of the default
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxy)
+		importClient.Transport = transport
+	}
+
+	importRequests = nil
+	if n := config.Import.RequestsPerMinute; n > 0 {
+		importRequests = &requestLimiter{interval: time.Minute / time.Duration(n)}
+	}
+
+	downloadRateLimit = int64(config.Import.DownloadRateLimit)
+}
+
+// ytdlpNetworkArgs returns the yt-dlp arguments for the proxy and rate limit.
+func ytdlpNetworkArgs() []string {
+	var args []string
+	if importProxy != "" {
+		args = append(args, "--proxy", importProxy)
+	}
+	if downloadRateLimit > 0 {
+		args = append(args, "--limit-rate", strconv.FormatInt(downloadRateLimit, 10))
+	}
+	return args
+}
+
+// waitForImportRequest blocks until the rate limit allows another import
+// request. Requests over the limit wait in line rather than fail; the job
+// shows StageDownloadQueued meanwhile.
+func waitForImportRequest(ctx context.Context) error {
+	if importRequests == nil {
+		return nil
+	}
+	return importRequests.wait(ctx, func() { setStage(ctx, StageDownloadQueued) })
+}
+
+// requestLimiter lets requests through evenly spaced, one per interval.
+type requestLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait reserves the next free slot and blocks until it comes, calling
+// queued first if that is in the future. A cancelled wait gives up its slot
+// only if no later one was reserved since.
+func (l *requestLimiter) wait(ctx context.Context, queued func()) error {
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	if queued != nil {
+		queued()
+	}
+
+	if err := sleepContext(ctx, delay); err != nil {
+		l.mu.Lock()
+		if l.next.Equal(slot.Add(l.interval)) {
+			l.next = slot
+		}
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// throttledReader reads no faster than rate bytes per second on average.
+type throttledReader struct {
+	ctx   context.Context
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func newThrottledReader(ctx context.Context, r io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, rate: rate, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	// Read at most a second's worth at a time so the pace stays smooth
+	if int64(len(p)) > t.rate {
+		p = p[:t.rate]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		if serr := sleepContext(t.ctx, wait); serr != nil {
+			return n, fmt.Errorf("download interrupted: %w", serr)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// resetOutboundSettings restores the outbound settings after a test that
+// loads them from the environment.
+func resetOutboundSettings(t *testing.T) {
+	t.Helper()
+
+	proxy, rate, requests, transport := importProxy, downloadRateLimit, importRequests, importClient.Transport
+	t.Cleanup(func() {
+		importProxy, downloadRateLimit, importRequests = proxy, rate, requests
+		importClient.Transport = transport
+	})
+}
+
+func TestLoadOutboundSettings(t *testing.T) {
+	resetOutboundSettings(t)
+	t.Setenv("IMPORT_PROXY", "socks5://127.0.0.1:1080")
+	t.Setenv("IMPORT_REQUESTS_PER_MINUTE", "30")
+	t.Setenv("DOWNLOAD_RATE_LIMIT", "2MB")
+	useTestConfig(t, &configFlags{})
+	loadOutboundSettings()
+
+	if importRequests == nil || importRequests.interval != 2*time.Second {
+		t.Errorf("Expected requests every 2s, got %+v", importRequests)
+	}
+
+	transport, ok := importClient.Transport.(*http.Transport)
+	if !ok || transport.Proxy == nil || transport.TLSHandshakeTimeout == 0 || !transport.ForceAttemptHTTP2 {
+		t.Errorf("Expected the default transport with the proxy, got %+v", importClient.Transport)
+	}
+
+	want := []string{"--proxy", "socks5://127.0.0.1:1080", "--limit-rate", "2097152"}
+	if args := ytdlpNetworkArgs(); !slices.Equal(args, want) {
+		t.Errorf("Expected yt-dlp arguments %v, got %v", want, args)
+	}
+}
+
+func TestRequestLimiter(t *testing.T) {
+	limiter := &requestLimiter{interval: 50 * time.Millisecond}
+
+	queued := 0
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(context.Background(), func() { queued++ }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first request goes through at once, the others wait their turn
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected 3 requests to take at least 100ms, took %v", elapsed)
+	}
+	if queued != 2 {
+		t.Errorf("Expected 2 requests to be queued, got %d", queued)
+	}
+}
+
+func TestRequestLimiterCancel(t *testing.T) {
+	limiter := &requestLimiter{interval: time.Hour}
+	if err := limiter.wait(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx, nil); err != context.DeadlineExceeded {
+		t.Fatalf("Expected the wait to be cancelled, got %v", err)
+	}
+
+	// The cancelled request's slot is free again
+	if next := time.Until(limiter.next); next > time.Hour {
+		t.Errorf("Expected the cancelled slot to be released, next slot in %v", next)
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 3000)
+	r := newThrottledReader(context.Background(), bytes.NewReader(data), 10000)
+
+	start := time.Now()
+	read, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(data) {
+		t.Errorf("Expected %d bytes, got %d", len(data), len(read))
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Expected 3000 bytes at 10000 B/s to take about 300ms, took %v", elapsed)
+	}
+
+	if r := newThrottledReader(context.Background(), bytes.NewReader(data), 0); r == nil {
+		t.Error("Expected a reader without a limit")
+	}
+}
+
+func TestFetchAudioThroughProxy(t *testing.T) {
+	resetOutboundSettings(t)
+	allowImportDomains(t, "audio.test")
+
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("ID3 audio"))
+	}))
+	defer proxy.Close()
+
+	t.Setenv("IMPORT_PROXY", proxy.URL)
+	useTestConfig(t, &configFlags{})
+	loadOutboundSettings()
+
+	path, _, err := fetchAudio(context.Background(), "http://audio.test/song.mp3", t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to fetch audio through the proxy: %v", err)
+	}
+	if proxied != "http://audio.test/song.mp3" {
+		t.Errorf("Expected the request to go through the proxy, got %q", proxied)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "ID3 audio" {
+		t.Errorf("Expected the file to be downloaded, got %q", data)
+	}
+}
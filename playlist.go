@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Playlist is the list of videos in a YouTube playlist or channel tab,
+// shown for confirmation before importing.
+type Playlist struct {
+	ID      string          `json:"id"`
+	Title   string          `json:"title"`
+	URL     string          `json:"url"`
+	Entries []PlaylistEntry `json:"entries"`
+}
+
+type PlaylistEntry struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	URL      string  `json:"url"`
+	Duration float64 `json:"duration,omitempty"`
+}
+
+// flatEntry is an entry of yt-dlp's --flat-playlist output. Channels nest
+// their tabs as playlists of their own.
+type flatEntry struct {
+	Type       string      `json:"_type"`
+	IEKey      string      `json:"ie_key"`
+	ID         string      `json:"id"`
+	Title      string      `json:"title"`
+	URL        string      `json:"url"`
+	WebpageURL string      `json:"webpage_url"`
+	Duration   float64     `json:"duration"`
+	Entries    []flatEntry `json:"entries"`
+}
+
+// expandPlaylist lists the videos of a playlist or channel without
+// downloading them, with the cookies of user so that private playlists can
+// be listed too.
+func expandPlaylist(ctx context.Context, url, user string) (*Playlist, error) {
+	if err := waitForImportRequest(ctx); err != nil {
+		return nil, err
+	}
+
+	workDir := filepath.Join(config.Paths.Temp, "playlist-"+uuid.New().String())
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return nil, &jobError{"Failed to create temp directory", err}
+	}
+	defer os.RemoveAll(workDir)
+
+	cookies, err := cookieArgs(user, workDir)
+	if err != nil {
+		return nil, &jobError{"Failed to read YouTube cookies", err}
+	}
+	args := []string{
+		"--flat-playlist",
+		"--dump-single-json",
+		"--user-agent", getRandomUserAgent(),
+	}
+	args = append(append(append(args, cookies...), ytdlpNetworkArgs()...), url)
+	output, err := runStageOutput(ctx, StageDownloading, stageLimits[StageDownloading].Base, "yt-dlp", args...)
+	if err != nil {
+		return nil, downloadJobError(err)
+	}
+	return parsePlaylist(output, url)
+}
+
+// parsePlaylist reads yt-dlp's --dump-single-json output for a flat
+// playlist. Videos in nested playlists are included; links to other pages
+// of a channel are not.
+func parsePlaylist(output []byte, url string) (*Playlist, error) {
+	var root flatEntry
+	if err := json.Unmarshal(output, &root); err != nil {
+		return nil, fmt.Errorf("invalid yt-dlp playlist: %w", err)
+	}
+
+	playlist := &Playlist{ID: root.ID, Title: root.Title, URL: url, Entries: []PlaylistEntry{}}
+	if root.WebpageURL != "" {
+		playlist.URL = root.WebpageURL
+	}
+
+	seen := make(map[string]bool)
+	var collect func(entries []flatEntry)
+	collect = func(entries []flatEntry) {
+		for _, e := range entries {
+			if len(e.Entries) > 0 {
+				collect(e.Entries)
+				continue
+			}
+			if e.ID == "" || seen[e.ID] || (e.IEKey != "" && e.IEKey != "Youtube") {
+				continue
+			}
+			seen[e.ID] = true
+
+			entry := PlaylistEntry{ID: e.ID, Title: e.Title, URL: e.URL, Duration: e.Duration}
+			if !strings.HasPrefix(entry.URL, "http") {
+				entry.URL = "https://www.youtube.com/watch?v=" + e.ID
+			}
+			playlist.Entries = append(playlist.Entries, entry)
+		}
+	}
+	collect(root.Entries)
+
+	return playlist, nil
+}
+
+// importPlaylist expands a playlist URL. Without "confirm" it only returns
+// the entries; with it, one job is queued per selected video (every video if
+// none are selected) under a new import batch.
+func (s *server) importPlaylist(c *gin.Context) {
+	var req struct {
+		URL     string   `json:"url"`
+		Confirm bool     `json:"confirm"`
+		Videos  []string `json:"videos"`
+		// Whose cookies the downloads use
+		User string `json:"user"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	if req.URL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Playlist URL is required"})
+		return
+	}
+	if _, ok := checkImportURL(c, req.URL); !ok {
+		return
+	}
+
+	if req.User != "" && !cookieUserPattern.MatchString(req.User) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user name"})
+		return
+	}
+	if req.User != "" && !adminAuthorized(c) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Downloading with the cookies of a user requires the admin token"})
+		return
+	}
+
+	playlist, err := expandPlaylist(c.Request.Context(), req.URL, req.User)
+	if err != nil {
+		respondProcessingError(c, err)
+		return
+	}
+	if len(playlist.Entries) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The playlist has no videos"})
+		return
+	}
+
+	if !req.Confirm {
+		c.JSON(http.StatusOK, playlist)
+		return
+	}
+
+	selected := playlist.Entries
+	if len(req.Videos) > 0 {
+		byID := make(map[string]PlaylistEntry, len(playlist.Entries))
+		for _, e := range playlist.Entries {
+			byID[e.ID] = e
+		}
+
+		selected = nil
+		for _, id := range req.Videos {
+			e, ok := byID[id]
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Video %s is not in the playlist", id)})
+				return
+			}
+			selected = append(selected, e)
+		}
+	}
+
+	batch, err := s.jobs.queuePlaylist(playlist, selected, req.User)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create jobs"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, batch)
+}
+
+// queuePlaylist creates a batch with one queued YouTube job per entry,
+// downloading with the cookies of user, and starts processing it in the
+// background.
+func (r *jobRegistry) queuePlaylist(playlist *Playlist, entries []PlaylistEntry, user string) (*ImportBatch, error) {
+	batch := &ImportBatch{
+		ID:        uuid.New().String(),
+		Kind:      BatchKindPlaylist,
+		URL:       playlist.URL,
+		Title:     playlist.Title,
+		CreatedAt: time.Now(),
+	}
+	if err := r.saveImportBatch(batch); err != nil {
+		return nil, err
+	}
+
+	var queued []*Job
+	for _, e := range entries {
+		id := uuid.New().String()
+		job, err := r.createInBatch(batch.ID, id, JobKindYoutube, JobInput{
+			URL:       e.URL,
+			Title:     e.Title,
+			User:      user,
+			Original:  filepath.Join(config.Paths.Uploads, id+".mp3"),
+			Processed: filepath.Join(config.Paths.Processed, id+".mp3"),
+		})
+		if err != nil {
+			// Run whatever was queued already; it is part of the batch
+			go r.runImportBatch(queued)
+			return nil, err
+		}
+		queued = append(queued, job)
+	}
+
+	go r.runImportBatch(queued)
+	return r.getImportBatchByID(batch.ID)
+}
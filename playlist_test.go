@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParsePlaylist(t *testing.T) {
+	output, err := os.ReadFile("testdata/playlist.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playlist, err := parsePlaylist(output, "https://youtube.com/playlist?list=PLsetlist")
+	if err != nil {
+		t.Fatalf("Failed to parse playlist: %v", err)
+	}
+	if playlist.Title != "Summer Setlist" || playlist.URL != "https://www.youtube.com/playlist?list=PLsetlist" {
+		t.Errorf("Unexpected playlist: %+v", playlist)
+	}
+
+	// Repeated videos are listed once
+	if len(playlist.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(playlist.Entries))
+	}
+	if e := playlist.Entries[1]; e.Title != "Ballad" || e.URL != "https://www.youtube.com/watch?v=bbbbbbbbbbb" || e.Duration != 312 {
+		t.Errorf("Unexpected entry: %+v", e)
+	}
+}
+
+func TestParseChannel(t *testing.T) {
+	output, err := os.ReadFile("testdata/channel.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	playlist, err := parsePlaylist(output, "https://www.youtube.com/@theband")
+	if err != nil {
+		t.Fatalf("Failed to parse channel: %v", err)
+	}
+
+	// Videos of nested tabs are included, links to other tabs are not
+	if len(playlist.Entries) != 1 || playlist.Entries[0].ID != "ccccccccccc" {
+		t.Errorf("Expected the one video of the channel, got %+v", playlist.Entries)
+	}
+}
+
+func TestImportPlaylistRequiresURL(t *testing.T) {
+	router := setupRouter()
+
+	body, _ := json.Marshal(map[string]string{"url": ""})
+	req, _ := http.NewRequest("POST", "/api/youtube/playlist", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestGetImportBatch(t *testing.T) {
+	srv := setupTestDB(t)
+
+	batch := &ImportBatch{ID: "test-batch", Kind: BatchKindPlaylist, URL: "https://www.youtube.com/playlist?list=PLsetlist", Title: "Summer Setlist", CreatedAt: time.Now()}
+	if err := srv.jobs.saveImportBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := srv.jobs.createInBatch(batch.ID, "batch-job-1", JobKindYoutube, JobInput{URL: "https://www.youtube.com/watch?v=aaaaaaaaaaa", Title: "Opener"})
+	second, _ := srv.jobs.createInBatch(batch.ID, "batch-job-2", JobKindYoutube, JobInput{URL: "https://www.youtube.com/watch?v=bbbbbbbbbbb", Title: "Ballad"})
+	srv.jobs.create("other-job", JobKindYoutube, JobInput{})
+
+	router := setupServerRouter(srv)
+
+	// Queued jobs can be cancelled before their turn
+	req, _ := http.NewRequest("DELETE", "/api/jobs/"+second.ID, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+	if song, err := srv.jobs.runQueued(second); song != nil || err != nil {
+		t.Errorf("Expected a cancelled job to be skipped, got %v, %v", song, err)
+	}
+
+	req, _ = http.NewRequest("GET", "/api/batches/"+batch.ID, nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var got ImportBatch
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if got.Title != "Summer Setlist" || got.Status != "running" {
+		t.Errorf("Unexpected batch: %+v", got)
+	}
+	if len(got.Items) != 2 {
+		t.Fatalf("Expected 2 items, got %d", len(got.Items))
+	}
+	if got.Items[0].ID != first.ID || got.Items[0].Title != "Opener" || got.Items[0].Status != JobQueued || got.Items[0].BatchID != batch.ID {
+		t.Errorf("Unexpected first item: %+v", got.Items[0].Job)
+	}
+	if got.Items[1].Status != JobCancelled {
+		t.Errorf("Expected the second item to be cancelled, got %s", got.Items[1].Status)
+	}
+	if got.Counts[JobQueued] != 1 || got.Counts[JobCancelled] != 1 {
+		t.Errorf("Unexpected counts: %v", got.Counts)
+	}
+}
+
+func TestSaveImportBatchAgain(t *testing.T) {
+	srv := setupTestDB(t)
+
+	// A retried chapter split saves the batch of its job again
+	created := time.Now().Add(-time.Hour)
+	batch := &ImportBatch{ID: "split-job", Kind: BatchKindChapters, URL: "https://youtu.be/x", Title: "Album", CreatedAt: created}
+	if err := srv.jobs.saveImportBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+	retried := *batch
+	retried.Title = "Album (Remastered)"
+	retried.CreatedAt = time.Now()
+	if err := srv.jobs.saveImportBatch(&retried); err != nil {
+		t.Fatalf("Expected saving the batch again to succeed, got %v", err)
+	}
+
+	got, err := srv.jobs.getImportBatchByID(batch.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != retried.Title || !got.CreatedAt.Equal(created) {
+		t.Errorf("Expected the new title and the original creation time, got %q at %v", got.Title, got.CreatedAt)
+	}
+}
+
+func TestGetImportBatchNotFound(t *testing.T) {
+	srv := setupTestDB(t)
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("GET", "/api/batches/missing", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
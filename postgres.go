@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// database is an open SQLite or PostgreSQL database. Queries and migrations
+// are written for SQLite; rebind and schemaSQL adapt them when the database
+// is PostgreSQL.
+type database struct {
+	*sql.DB
+	// "sqlite3" or "postgres"
+	driver string
+}
+
+// rebind rewrites the ? placeholders of query as $1, $2, ... when the
+// database is PostgreSQL. Queries must not contain a literal question mark.
+func (d *database) rebind(query string) string {
+	if d.driver != "postgres" || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+// The column types of the migrations that PostgreSQL lacks or that are too
+// small there: INTEGER is 32 bits and REAL is single precision.
+var sqliteTypes = regexp.MustCompile(`\b(DATETIME|INTEGER|REAL)\b`)
+
+var postgresTypes = map[string]string{
+	"DATETIME": "TIMESTAMPTZ",
+	"INTEGER":  "BIGINT",
+	"REAL":     "DOUBLE PRECISION",
+}
+
+// schemaSQL translates the column types of a migration when the database is
+// PostgreSQL.
+func (d *database) schemaSQL(statements string) string {
+	if d.driver != "postgres" {
+		return statements
+	}
+	return sqliteTypes.ReplaceAllStringFunc(statements, func(name string) string {
+		return postgresTypes[name]
+	})
+}
+
+// migrationLockID is the PostgreSQL advisory lock held while migrating, so
+// that instances starting together apply each migration once.
+const migrationLockID = 0x6472756d // "drum"
+
+// lockMigrations waits until no other instance is migrating the database.
+// The lock is released with tx. SQLite needs no lock, as its transactions
+// already exclude each other.
+func (d *database) lockMigrations(tx *sql.Tx) error {
+	if d.driver != "postgres" {
+		return nil
+	}
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID)
+	return err
+}
+
+// tableExists tells whether the database has a table called name.
+func (d *database) tableExists(name string) (bool, error) {
+	query := `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`
+	if d.driver == "postgres" {
+		query = `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?`
+	}
+	var count int
+	err := d.QueryRow(d.rebind(query), name).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		driver string
+		query  string
+		want   string
+	}{
+		{"sqlite3", `UPDATE songs SET name = ? WHERE id = ?`, `UPDATE songs SET name = ? WHERE id = ?`},
+		{"postgres", `UPDATE songs SET name = ? WHERE id = ?`, `UPDATE songs SET name = $1 WHERE id = $2`},
+		{"postgres", `SELECT COUNT(*) FROM songs`, `SELECT COUNT(*) FROM songs`},
+		{"postgres", `DELETE FROM jobs WHERE id = ?`, `DELETE FROM jobs WHERE id = $1`},
+		{"postgres", `INSERT INTO sources (song_id, url, chapters) VALUES (?, ?, ?)`, `INSERT INTO sources (song_id, url, chapters) VALUES ($1, $2, $3)`},
+		{"postgres", `SELECT id FROM jobs WHERE status IN (?, ?) AND instance IN (?, '')`, `SELECT id FROM jobs WHERE status IN ($1, $2) AND instance IN ($3, '')`},
+	}
+
+	for _, test := range tests {
+		db := &database{driver: test.driver}
+		if got := db.rebind(test.query); got != test.want {
+			t.Errorf("%s: Expected %q, got %q", test.driver, test.want, got)
+		}
+	}
+}
+
+func TestSchemaSQL(t *testing.T) {
+	tests := []struct {
+		driver    string
+		migration string
+		want      string
+	}{
+		{"sqlite3", "CREATE TABLE t (size INTEGER, duration REAL, created_at DATETIME);", "CREATE TABLE t (size INTEGER, duration REAL, created_at DATETIME);"},
+		{"postgres", "CREATE TABLE t (size INTEGER, duration REAL, created_at DATETIME);", "CREATE TABLE t (size BIGINT, duration DOUBLE PRECISION, created_at TIMESTAMPTZ);"},
+		{"postgres", "ALTER TABLE songs ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';", "ALTER TABLE songs ADD COLUMN parent_id TEXT NOT NULL DEFAULT '';"},
+		{"postgres", "CREATE TABLE t (interval_text TEXT, realm TEXT, datetime_format TEXT);", "CREATE TABLE t (interval_text TEXT, realm TEXT, datetime_format TEXT);"},
+		{"postgres", "CREATE TABLE a (n INTEGER);\nCREATE TABLE b (n INTEGER);", "CREATE TABLE a (n BIGINT);\nCREATE TABLE b (n BIGINT);"},
+	}
+
+	for _, test := range tests {
+		db := &database{driver: test.driver}
+		if got := db.schemaSQL(test.migration); got != test.want {
+			t.Errorf("%s: Expected %q, got %q", test.driver, test.want, got)
+		}
+	}
+}
+
+// usePostgres opens a new schema of the PostgreSQL database of
+// DRUMMER_TEST_POSTGRES_DSN, dropped at the end of the test, and returns a
+// server for it. The test is skipped when the variable is not set, e.g.
+// with make test-postgres.
+func usePostgres(t *testing.T) *server {
+	t.Helper()
+
+	dsn := os.Getenv("DRUMMER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("DRUMMER_TEST_POSTGRES_DSN is not set")
+	}
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("drummer_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("Expected DRUMMER_TEST_POSTGRES_DSN to be a URL: %v", err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	conn, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := &database{DB: conn, driver: "postgres"}
+	t.Cleanup(func() { db.Close() })
+	if err := db.runMigrations(); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+	return newServer(db, newSQLSongStore(db))
+}
+
+func TestPostgresSongStore(t *testing.T) {
+	testSongStore(t, usePostgres(t).songs)
+}
+
+func TestPostgresJobs(t *testing.T) {
+	srv := usePostgres(t)
+
+	job, err := srv.jobs.create("test-postgres-job", JobKindYoutube, JobInput{URL: "https://youtu.be/x"})
+	if err != nil {
+		t.Fatalf("Failed to create job: %v", err)
+	}
+	srv.jobs.update(job, func(j *Job) {
+		j.Status = JobRunning
+		j.Stage = StageDownloading
+		j.Attempts = 1
+	})
+
+	got, err := srv.jobs.getJobByID(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != JobRunning || got.Stage != StageDownloading || got.Input.URL != job.Input.URL {
+		t.Errorf("Expected the updated job, got %+v", got)
+	}
+	requeued, err := srv.jobs.recoverJobs()
+	if err != nil || len(requeued) != 1 {
+		t.Errorf("Expected the running job to be re-queued, got %v, %v", requeued, err)
+	}
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	srv := usePostgres(t)
+
+	statuses, err := srv.db.migrationStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			t.Errorf("Expected migration %d to be applied", status.Version)
+		}
+	}
+
+	// Another instance starting on the same database changes nothing
+	if err := srv.db.runMigrations(); err != nil {
+		t.Errorf("Expected applied migrations to be skipped, got %v", err)
+	}
+	var count int
+	srv.db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&count)
+	if count != len(statuses) {
+		t.Errorf("Expected %d recorded migrations, got %d", len(statuses), count)
+	}
+
+	// The columns hold what SQLite allowed, e.g. sizes over 2 GiB
+	entry := strings.Repeat("a", 64)
+	_, err = srv.db.ExecContext(context.Background(), srv.db.rebind(`INSERT INTO render_cache (key, audio_hash, backend, model, recipe, format, size, created_at, last_used_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`), entry, entry, "spleeter", "model", "recipe", "mp3", int64(3)<<30, time.Now(), time.Now())
+	if err != nil {
+		t.Errorf("Failed to cache a large render: %v", err)
+	}
+}
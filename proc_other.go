@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// configureProcessGroup is a no-op on platforms without process groups;
+// cancellation only kills the direct child.
+func configureProcessGroup(cmd *exec.Cmd) {}
@@ -0,0 +1,30 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCommandContextKillsProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// The shell waits on a grandchild that holds the output pipe open; only
+	// killing the whole group lets Wait return promptly.
+	cmd := commandContext(ctx, "sh", "-c", "sleep 30 & wait")
+	if err := cmd.Start(); err != nil {
+		t.Skipf("sh not available: %v", err)
+	}
+
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	if err := cmd.Wait(); err == nil {
+		t.Error("Expected an error from a cancelled command")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected command to be killed promptly, took %v", elapsed)
+	}
+}
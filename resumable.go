@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Resumable uploads follow the tus protocol (https://tus.io/protocols/
+// resumable-upload) with the creation, expiration and termination
+// extensions. Once the last byte arrives, the file is processed like any
+// other upload.
+const tusVersion = "1.0.0"
+
+// Directory holding the files of uploads that are still incomplete.
+var partialUploadsDir = filepath.Join(config.Paths.Uploads, "partial")
+
+// errUploadDataLost means the partial file of an upload is gone or shorter
+// than what was received, so the upload can't be resumed.
+var errUploadDataLost = errors.New("the data received so far is lost")
+
+var (
+	// Largest file accepted for a resumable upload, and the most an upload
+	// archive may unpack to
+	maxResumableSize int64 = 2 << 30
+	// Time an incomplete upload is kept since it was created
+	resumableExpiry = 24 * time.Hour
+)
+
+// ResumableUpload is a file being uploaded in pieces. Received is the
+// offset the client resumes from.
+type ResumableUpload struct {
+	ID        string `json:"id"`
+	Filename  string `json:"filename"`
+	Length    int64  `json:"length"`
+	Received  int64  `json:"received"`
+	Duplicate string `json:"-"`
+	// The job processing the completed upload, or the existing song it
+	// turned out to duplicate
+	JobID     string    `json:"job_id,omitempty"`
+	SongID    string    `json:"song_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (u *ResumableUpload) complete() bool {
+	return u.Received == u.Length
+}
+
+func (u *ResumableUpload) expired() bool {
+	return !u.complete() && time.Now().After(u.ExpiresAt)
+}
+
+// Uploads currently receiving a PATCH; tus clients must not send two at
+// once.
+var (
+	patchingMu sync.Mutex
+	patching   = make(map[string]bool)
+)
+
+// loadResumableSettings applies the configured upload size, expiry and
+// directory.
+func loadResumableSettings() {
+	maxResumableSize = int64(config.Uploads.MaxSize)
+	resumableExpiry = config.Uploads.Expiry
+	partialUploadsDir = filepath.Join(config.Paths.Uploads, "partial")
+}
+
+func partialUploadPath(id string) string {
+	return filepath.Join(partialUploadsDir, id)
+}
+
+const resumableColumns = `id, filename, length, received, duplicate, job_id, song_id, created_at, expires_at`
+
+func scanResumableUpload(row interface{ Scan(...any) error }) (*ResumableUpload, error) {
+	var u ResumableUpload
+	err := row.Scan(&u.ID, &u.Filename, &u.Length, &u.Received, &u.Duplicate, &u.JobID, &u.SongID, &u.CreatedAt, &u.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *server) saveResumableUpload(u *ResumableUpload) error {
+	query := `INSERT INTO resumable_uploads (` + resumableColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.Exec(s.db.rebind(query), u.ID, u.Filename, u.Length, u.Received, u.Duplicate, u.JobID, u.SongID, u.CreatedAt, u.ExpiresAt)
+	return err
+}
+
+func (s *server) updateResumableUpload(u *ResumableUpload) error {
+	query := `UPDATE resumable_uploads SET received = ?, job_id = ?, song_id = ? WHERE id = ?`
+	_, err := s.db.Exec(s.db.rebind(query), u.Received, u.JobID, u.SongID, u.ID)
+	return err
+}
+
+func (s *server) getResumableUpload(id string) (*ResumableUpload, error) {
+	return scanResumableUpload(s.db.QueryRow(s.db.rebind(`SELECT `+resumableColumns+` FROM resumable_uploads WHERE id = ?`), id))
+}
+
+// removeResumableUpload deletes an upload and its partial file.
+func (s *server) removeResumableUpload(id string) error {
+	if _, err := s.db.Exec(s.db.rebind(`DELETE FROM resumable_uploads WHERE id = ?`), id); err != nil {
+		return err
+	}
+	if err := os.Remove(partialUploadPath(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeExpiredUploads deletes incomplete uploads that expired, along with
+// whatever was received of them. Complete uploads are kept, as they show
+// the job or song they became.
+func (s *server) removeExpiredUploads() {
+	rows, err := s.db.Query(s.db.rebind(`SELECT `+resumableColumns+` FROM resumable_uploads WHERE expires_at < ? AND received < length`), time.Now())
+	if err != nil {
+		log.Printf("Failed to list expired uploads: %v", err)
+		return
+	}
+	var expired []*ResumableUpload
+	for rows.Next() {
+		u, err := scanResumableUpload(rows)
+		if err != nil {
+			log.Printf("Failed to read expired upload: %v", err)
+			continue
+		}
+		expired = append(expired, u)
+	}
+	rows.Close()
+
+	for _, u := range expired {
+		if err := s.removeResumableUpload(u.ID); err != nil {
+			log.Printf("Failed to remove expired upload %s: %v", u.ID, err)
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("Removed %d expired uploads", len(expired))
+	}
+}
+
+// startUploadJanitor removes expired uploads now and then periodically.
+func (s *server) startUploadJanitor() {
+	s.removeExpiredUploads()
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.removeExpiredUploads()
+		}
+	}()
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma-separated
+// keys, each followed by a space and its base64-encoded value.
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %q", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// tusHeaders adds the protocol version to every response and rejects
+// requests made for another version. OPTIONS and the JSON status endpoint
+// are open to any client.
+func tusHeaders(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	method := c.Request.Method
+	if method != http.MethodOptions && method != http.MethodGet && c.GetHeader("Tus-Resumable") != tusVersion {
+		c.Header("Tus-Version", tusVersion)
+		c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported tus version"})
+		return
+	}
+	c.Next()
+}
+
+func tusOptions(c *gin.Context) {
+	c.Header("Tus-Version", tusVersion)
+	c.Header("Tus-Extension", "creation,expiration,termination")
+	c.Header("Tus-Max-Size", strconv.FormatInt(maxResumableSize, 10))
+	c.Status(http.StatusNoContent)
+}
+
+func uploadHeaders(c *gin.Context, u *ResumableUpload) {
+	c.Header("Upload-Offset", strconv.FormatInt(u.Received, 10))
+	c.Header("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if !u.complete() {
+		c.Header("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	c.Header("Cache-Control", "no-store")
+}
+
+// createResumableUpload starts an upload of Upload-Length bytes. The
+// metadata must include the file name and may set "duplicate" like the
+// form field of POST /api/upload.
+func (s *server) createResumableUpload(c *gin.Context) {
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+		return
+	}
+	if length > maxResumableSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is too large"})
+		return
+	}
+
+	metadata, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid Upload-Metadata: %v", err)})
+		return
+	}
+	filename := filepath.Base(metadata["filename"])
+	if !audioExtensions[strings.ToLower(filepath.Ext(filename))] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A filename of a supported audio format is required"})
+		return
+	}
+	duplicate := metadata["duplicate"]
+	if duplicate == "" {
+		duplicate = DuplicateExisting
+	}
+	if duplicate != DuplicateExisting && duplicate != DuplicateNew {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "duplicate must be \"existing\" or \"new\""})
+		return
+	}
+
+	if err := os.MkdirAll(partialUploadsDir, 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	now := time.Now()
+	u := &ResumableUpload{
+		ID:        uuid.New().String(),
+		Filename:  filename,
+		Length:    length,
+		Duplicate: duplicate,
+		CreatedAt: now,
+		ExpiresAt: now.Add(resumableExpiry),
+	}
+	f, err := os.Create(partialUploadPath(u.ID))
+	if err == nil {
+		err = f.Close()
+	}
+	if err == nil {
+		err = s.saveResumableUpload(u)
+	}
+	if err != nil {
+		os.Remove(partialUploadPath(u.ID))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create upload"})
+		return
+	}
+
+	c.Header("Location", "/api/uploads/"+u.ID)
+	uploadHeaders(c, u)
+	c.Status(http.StatusCreated)
+}
+
+// findResumableUpload loads the upload named in the URL, responding with an
+// error if it does not exist or has expired.
+func (s *server) findResumableUpload(c *gin.Context) (*ResumableUpload, bool) {
+	u, err := s.getResumableUpload(c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Upload not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch upload"})
+		return nil, false
+	}
+	if u.expired() {
+		c.JSON(http.StatusGone, gin.H{"error": "Upload has expired"})
+		return nil, false
+	}
+	return u, true
+}
+
+func (s *server) headResumableUpload(c *gin.Context) {
+	u, ok := s.findResumableUpload(c)
+	if !ok {
+		return
+	}
+	uploadHeaders(c, u)
+	c.Status(http.StatusOK)
+}
+
+// getResumableUploadStatus shows an upload as JSON, including the job
+// processing it once it is complete.
+func (s *server) getResumableUploadStatus(c *gin.Context) {
+	u, ok := s.findResumableUpload(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, u)
+}
+
+// patchResumableUpload appends the request body to an upload at
+// Upload-Offset. Whatever arrives is kept even if the connection drops, so
+// the client can resume from the offset reported by HEAD.
+func (s *server) patchResumableUpload(c *gin.Context) {
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+		return
+	}
+
+	id := c.Param("id")
+	patchingMu.Lock()
+	busy := patching[id]
+	patching[id] = true
+	patchingMu.Unlock()
+	if busy {
+		c.JSON(http.StatusLocked, gin.H{"error": "The upload is already receiving data"})
+		return
+	}
+	defer func() {
+		patchingMu.Lock()
+		delete(patching, id)
+		patchingMu.Unlock()
+	}()
+
+	u, ok := s.findResumableUpload(c)
+	if !ok {
+		return
+	}
+	if offset != u.Received {
+		uploadHeaders(c, u)
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Upload-Offset must be %d", u.Received)})
+		return
+	}
+	if u.complete() {
+		uploadHeaders(c, u)
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if c.Request.ContentLength > u.Length-u.Received {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "The data exceeds Upload-Length"})
+		return
+	}
+
+	n, err := appendToUpload(u, c.Request.Body)
+	if errors.Is(err, errUploadDataLost) {
+		log.Printf("Upload %s can't be resumed at %d bytes: %v", u.ID, u.Received, err)
+		uploadHeaders(c, u)
+		c.JSON(http.StatusConflict, gin.H{"error": "The data received so far is lost; start a new upload"})
+		return
+	}
+	if err == nil && u.Received+n == u.Length {
+		// The upload only counts as complete once it has been handed off,
+		// so a client retrying after a failure sends the last part again
+		u.Received += n
+		if err := s.finishResumableUpload(c.Request.Context(), u); err != nil {
+			log.Printf("Failed to process upload %s: %v", u.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process upload"})
+			return
+		}
+		uploadHeaders(c, u)
+		c.Status(http.StatusNoContent)
+		return
+	}
+	if n > 0 {
+		u.Received += n
+		if uerr := s.updateResumableUpload(u); uerr != nil {
+			log.Printf("Failed to record progress of upload %s: %v", u.ID, uerr)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save upload"})
+			return
+		}
+	}
+	if err != nil {
+		log.Printf("Upload %s interrupted at %d of %d bytes: %v", u.ID, u.Received, u.Length, err)
+		uploadHeaders(c, u)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Upload interrupted"})
+		return
+	}
+
+	uploadHeaders(c, u)
+	c.Status(http.StatusNoContent)
+}
+
+// appendToUpload writes body to the partial file of u at its offset,
+// dropping anything a previous interrupted write left past it. It returns
+// how many bytes were stored, or errUploadDataLost if the file doesn't hold
+// everything received so far.
+func appendToUpload(u *ResumableUpload, body io.Reader) (int64, error) {
+	f, err := os.OpenFile(partialUploadPath(u.ID), os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return 0, errUploadDataLost
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < u.Received {
+		return 0, errUploadDataLost
+	}
+	if err := f.Truncate(u.Received); err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(u.Received, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, io.LimitReader(body, u.Length-u.Received))
+	if serr := f.Sync(); err == nil {
+		err = serr
+	}
+	return n, err
+}
+
+// finishResumableUpload hands a complete upload to the processing pipeline
+// and records it as complete. A file already in the library is resolved
+// like a regular upload; otherwise a job with the upload's ID is queued. If
+// it fails, the partial file is left as it was for the client to retry.
+func (s *server) finishResumableUpload(ctx context.Context, u *ResumableUpload) error {
+	partial := partialUploadPath(u.ID)
+	hash, err := hashFile(partial)
+	if err != nil {
+		return err
+	}
+
+	// Songs are named after the file without its extension
+	filename := strings.TrimSuffix(u.Filename, filepath.Ext(u.Filename)) + ".mp3"
+
+	song, err := findDuplicate(ctx, s.songs, hash, u.Duplicate, filename)
+	if err != nil {
+		return err
+	}
+	if song != nil {
+		u.SongID = song.ID
+		if err := s.updateResumableUpload(u); err != nil {
+			u.SongID = ""
+			return err
+		}
+		os.Remove(partial)
+		return nil
+	}
+
+	input := JobInput{
+		Filename:    filename,
+		Original:    filepath.Join(config.Paths.Uploads, u.ID+".mp3"),
+		Processed:   filepath.Join(config.Paths.Processed, u.ID+".mp3"),
+		ContentHash: hash,
+	}
+	received := input.Original
+	if ext := strings.ToLower(filepath.Ext(u.Filename)); ext != ".mp3" {
+		input.Upload = filepath.Join(config.Paths.Uploads, u.ID+ext)
+		received = input.Upload
+	}
+	if err := os.Rename(partial, received); err != nil {
+		return err
+	}
+
+	job, err := s.jobs.create(u.ID, JobKindUpload, input)
+	if err != nil {
+		os.Rename(received, partial)
+		return err
+	}
+	u.JobID = job.ID
+	if err := s.updateResumableUpload(u); err != nil {
+		log.Printf("Failed to record job of upload %s: %v", u.ID, err)
+	}
+
+	go func() {
+		if _, err := s.jobs.runQueued(job); err != nil {
+			log.Printf("Upload job %s failed: %v", job.ID, err)
+		}
+	}()
+	return nil
+}
+
+// terminateResumableUpload deletes an incomplete upload.
+func (s *server) terminateResumableUpload(c *gin.Context) {
+	u, ok := s.findResumableUpload(c)
+	if !ok {
+		return
+	}
+	if u.complete() {
+		c.JSON(http.StatusConflict, gin.H{"error": "The upload is complete; cancel its job instead"})
+		return
+	}
+
+	patchingMu.Lock()
+	busy := patching[u.ID]
+	patchingMu.Unlock()
+	if busy {
+		c.JSON(http.StatusLocked, gin.H{"error": "The upload is receiving data"})
+		return
+	}
+
+	if err := s.removeResumableUpload(u.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete upload"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
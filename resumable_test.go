@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func tusRequest(t *testing.T, router *gin.Engine, method, path string, body io.Reader, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, path, body)
+	req.Header.Set("Tus-Resumable", tusVersion)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// createTestUpload starts a resumable upload of length bytes and returns
+// its URL.
+func createTestUpload(t *testing.T, router *gin.Engine, filename string, length int) string {
+	t.Helper()
+
+	w := tusRequest(t, router, "POST", "/api/uploads", nil, map[string]string{
+		"Upload-Length":   strconv.Itoa(length),
+		"Upload-Metadata": "filename " + base64.StdEncoding.EncodeToString([]byte(filename)),
+	})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusCreated, w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	t.Cleanup(func() { os.Remove(partialUploadPath(location[len("/api/uploads/"):])) })
+	return location
+}
+
+func patchTestUpload(t *testing.T, router *gin.Engine, location string, offset int, data io.Reader) *httptest.ResponseRecorder {
+	t.Helper()
+
+	return tusRequest(t, router, "PATCH", location, data, map[string]string{
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": strconv.Itoa(offset),
+	})
+}
+
+// failingReader returns its data and then an error, like a dropped
+// connection.
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestTusOptionsAndVersion(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	req, _ := http.NewRequest("OPTIONS", "/api/uploads", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusNoContent || w.Header().Get("Tus-Version") != tusVersion {
+		t.Errorf("Expected the supported version, got %d %v", w.Code, w.Header())
+	}
+	if w.Header().Get("Tus-Extension") != "creation,expiration,termination" {
+		t.Errorf("Expected the supported extensions, got %q", w.Header().Get("Tus-Extension"))
+	}
+
+	req, _ = http.NewRequest("POST", "/api/uploads", nil)
+	req.Header.Set("Tus-Resumable", "0.2.2")
+	req.Header.Set("Upload-Length", "10")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code %d, got %d", http.StatusPreconditionFailed, w.Code)
+	}
+}
+
+func TestCreateResumableUploadValidation(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	tests := []struct {
+		headers map[string]string
+		status  int
+	}{
+		{map[string]string{"Upload-Metadata": "filename " + encode("song.flac")}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename " + encode("notes.txt")}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename !!!"}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": "10", "Upload-Metadata": "filename " + encode("song.mp3") + ",duplicate " + encode("maybe")}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": strconv.FormatInt(maxResumableSize+1, 10), "Upload-Metadata": "filename " + encode("song.mp3")}, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		w := tusRequest(t, router, "POST", "/api/uploads", nil, test.headers)
+		if w.Code != test.status {
+			t.Errorf("%v: Expected status code %d, got %d", test.headers, test.status, w.Code)
+		}
+	}
+}
+
+func TestResumableUploadResume(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	content := bytes.Repeat([]byte("flac"), 256)
+	location := createTestUpload(t, router, "Rehearsal.flac", len(content))
+
+	// The connection drops after the first part; what arrived is kept
+	w := patchTestUpload(t, router, location, 0, &failingReader{data: content[:300]})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an interrupted upload, got %d", w.Code)
+	}
+
+	w = tusRequest(t, router, "HEAD", location, nil, nil)
+	if w.Code != http.StatusOK || w.Header().Get("Upload-Offset") != "300" {
+		t.Fatalf("Expected offset 300, got %d %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w.Header().Get("Upload-Length") != strconv.Itoa(len(content)) || w.Header().Get("Upload-Expires") == "" {
+		t.Errorf("Expected the length and expiry, got %v", w.Header())
+	}
+
+	// Resuming from the wrong offset is refused
+	w = patchTestUpload(t, router, location, 0, bytes.NewReader(content))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+
+	w = patchTestUpload(t, router, location, 300, bytes.NewReader(content[300:600]))
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != "600" {
+		t.Fatalf("Expected offset 600, got %d %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	data, err := os.ReadFile(partialUploadPath(location[len("/api/uploads/"):]))
+	if err != nil || !bytes.Equal(data, content[:600]) {
+		t.Errorf("Expected the partial file to hold the first 600 bytes, got %d bytes, %v", len(data), err)
+	}
+
+	// More than the declared length is refused
+	w = patchTestUpload(t, router, location, 600, bytes.NewReader(content))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+
+	w = tusRequest(t, router, "PATCH", location, bytes.NewReader(content[600:]), map[string]string{"Upload-Offset": "600"})
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status code %d, got %d", http.StatusUnsupportedMediaType, w.Code)
+	}
+}
+
+func TestResumableUploadLostData(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	content := bytes.Repeat([]byte("flac"), 256)
+	location := createTestUpload(t, router, "Rehearsal.flac", len(content))
+	partial := partialUploadPath(location[len("/api/uploads/"):])
+
+	w := patchTestUpload(t, router, location, 0, bytes.NewReader(content[:300]))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	// Bytes past the offset are left from an interrupted write and dropped
+	f, _ := os.OpenFile(partial, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte("left over"))
+	f.Close()
+	w = patchTestUpload(t, router, location, 300, bytes.NewReader(content[300:600]))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNoContent, w.Code)
+	}
+	data, err := os.ReadFile(partial)
+	if err != nil || !bytes.Equal(data, content[:600]) {
+		t.Errorf("Expected the partial file to hold the first 600 bytes, got %d bytes, %v", len(data), err)
+	}
+
+	// A partial file shorter than what was received can't be resumed
+	os.Truncate(partial, 100)
+	w = patchTestUpload(t, router, location, 600, bytes.NewReader(content[600:]))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d for a truncated file, got %d", http.StatusConflict, w.Code)
+	}
+
+	os.Remove(partial)
+	w = patchTestUpload(t, router, location, 600, bytes.NewReader(content[600:]))
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d for a missing file, got %d", http.StatusConflict, w.Code)
+	}
+	if _, err := os.Stat(partial); !os.IsNotExist(err) {
+		t.Errorf("Expected the missing file not to be recreated, got %v", err)
+	}
+}
+
+func TestResumableUploadDuplicate(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	content := []byte("a song that was uploaded before")
+	existing := saveHashedSong(t, srv.songs, "existing", content)
+
+	location := createTestUpload(t, router, "Again.mp3", len(content))
+	w := patchTestUpload(t, router, location, 0, bytes.NewReader(content))
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(len(content)) {
+		t.Fatalf("Expected the upload to complete, got %d %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	w = tusRequest(t, router, "GET", location, nil, nil)
+	var status ResumableUpload
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if status.SongID != existing.ID || status.JobID != "" {
+		t.Errorf("Expected the upload to resolve to song %s without a job, got %+v", existing.ID, status)
+	}
+	if _, err := os.Stat(partialUploadPath(status.ID)); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be removed")
+	}
+
+	// A complete upload cannot be terminated
+	w = tusRequest(t, router, "DELETE", location, nil, nil)
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestResumableUploadExpiryAndTermination(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	expiring := createTestUpload(t, router, "Old.wav", 100)
+	id := expiring[len("/api/uploads/"):]
+	if _, err := srv.db.Exec(`UPDATE resumable_uploads SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Minute), id); err != nil {
+		t.Fatal(err)
+	}
+
+	w := patchTestUpload(t, router, expiring, 0, bytes.NewReader(make([]byte, 10)))
+	if w.Code != http.StatusGone {
+		t.Errorf("Expected status code %d, got %d", http.StatusGone, w.Code)
+	}
+
+	srv.removeExpiredUploads()
+	if _, err := srv.getResumableUpload(id); err == nil {
+		t.Error("Expected the expired upload to be removed")
+	}
+	if _, err := os.Stat(partialUploadPath(id)); !os.IsNotExist(err) {
+		t.Error("Expected the partial file of the expired upload to be removed")
+	}
+
+	location := createTestUpload(t, router, "Take 2.m4a", 100)
+	w = tusRequest(t, router, "DELETE", location, nil, nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, got %d", http.StatusNoContent, w.Code)
+	}
+	w = tusRequest(t, router, "HEAD", location, nil, nil)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestResumableUploadRetriedAfterFailedHandoff(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	content := []byte("a song whose handoff fails")
+	location := createTestUpload(t, router, "Retry.mp3", len(content))
+	id := location[len("/api/uploads/"):]
+
+	// The file cannot be moved into the uploads directory
+	old := config
+	cfg := *config
+	cfg.Paths.Uploads = filepath.Join(t.TempDir(), "missing")
+	config = &cfg
+	w := patchTestUpload(t, router, location, 0, bytes.NewReader(content))
+	config = old
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status code %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// The upload is not complete, so the client sends the last part again
+	w = tusRequest(t, router, "HEAD", location, nil, nil)
+	if w.Header().Get("Upload-Offset") != "0" {
+		t.Fatalf("Expected offset 0 after the failed handoff, got %q", w.Header().Get("Upload-Offset"))
+	}
+	existing := saveHashedSong(t, srv.songs, "existing", content)
+	w = patchTestUpload(t, router, location, 0, bytes.NewReader(content))
+	if w.Code != http.StatusNoContent || w.Header().Get("Upload-Offset") != strconv.Itoa(len(content)) {
+		t.Fatalf("Expected the retried upload to complete, got %d %q", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if u, err := srv.getResumableUpload(id); err != nil || u.SongID != existing.ID {
+		t.Errorf("Expected the upload to resolve to song %s, got %+v, %v", existing.ID, u, err)
+	}
+
+	// Complete uploads outlive their expiry, as they point to the result
+	if _, err := srv.db.Exec(`UPDATE resumable_uploads SET expires_at = ? WHERE id = ?`, time.Now().Add(-time.Minute), id); err != nil {
+		t.Fatal(err)
+	}
+	srv.removeExpiredUploads()
+	if _, err := srv.getResumableUpload(id); err != nil {
+		t.Errorf("Expected the complete upload to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// retryPolicy controls how often a failing stage is retried. The delay
+// before retry n is BaseDelay*2^(n-1), capped at MaxDelay, plus random
+// jitter of up to the same amount (at most a second).
+type retryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func (p retryPolicy) delay(attempt int) time.Duration {
+	d := p.BaseDelay << (attempt - 1)
+	if d > p.MaxDelay || d <= 0 {
+		d = p.MaxDelay
+	}
+	jitter := d
+	if jitter > time.Second {
+		jitter = time.Second
+	}
+	return d + time.Duration(rand.Int63n(int64(jitter)+1))
+}
+
+var stageRetryPolicies = map[string]retryPolicy{
+	StageDownloading: {MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second},
+	StageSeparating:  {MaxAttempts: 2, BaseDelay: 10 * time.Second, MaxDelay: time.Minute},
+	StageMixing:      {MaxAttempts: 2, BaseDelay: 2 * time.Second, MaxDelay: 30 * time.Second},
+}
+
+// loadRetryPolicies applies the configured number of attempts per stage.
+func loadRetryPolicies() {
+	attempts := map[string]int{
+		StageDownloading: config.Retries.Download,
+		StageSeparating:  config.Retries.Separation,
+		StageMixing:      config.Retries.Mixing,
+	}
+	for stage, n := range attempts {
+		policy := stageRetryPolicies[stage]
+		policy.MaxAttempts = n
+		stageRetryPolicies[stage] = policy
+	}
+}
+
+// Tool output that means trying again will not help. yt-dlp failures are
+// classified by parseYtdlpOutput instead.
+var permanentFailurePatterns = []string{
+	"Invalid data found when processing input",
+	"No such file or directory",
+}
+
+// isRetryable reports whether a stage failure may succeed on another attempt.
+// Tool failures are assumed transient (OOM kills, network errors, model
+// downloads) unless their output says otherwise. Cancellations and timeouts
+// are final: a stage that used up its time limit is unlikely to finish
+// within it next time.
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var serr *stageError
+	if !errors.As(err, &serr) {
+		return false
+	}
+	if serr.TimedOut {
+		return false
+	}
+
+	if yerr := parseYtdlpOutput(serr.Output); yerr != nil && yerr.Permanent() {
+		return false
+	}
+	for _, pattern := range permanentFailurePatterns {
+		if strings.Contains(serr.Output, pattern) {
+			return false
+		}
+	}
+	return true
+}
+
+// retryStage runs attempt until it succeeds, fails permanently or the
+// stage's policy runs out of attempts, waiting between attempts with
+// exponential backoff.
+func retryStage(ctx context.Context, stage string, attempt func(n int) error) error {
+	policy := stageRetryPolicies[stage]
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	var err error
+	for n := 1; n <= policy.MaxAttempts; n++ {
+		err = attempt(n)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !isRetryable(err) {
+			log.Printf("Stage %s failed permanently on attempt %d: %v", stage, n, err)
+			return err
+		}
+
+		log.Printf("Stage %s attempt %d/%d failed: %v", stage, n, policy.MaxAttempts, err)
+
+		// Don't sleep after the last attempt
+		if n < policy.MaxAttempts {
+			wait := policy.delay(n)
+			log.Printf("Waiting %v before retry...", wait)
+			if err := sleepContext(ctx, wait); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
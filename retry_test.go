@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := retryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	tests := []struct {
+		attempt int
+		min     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second}, // capped
+	}
+	for _, tt := range tests {
+		d := policy.delay(tt.attempt)
+		if d < tt.min || d > tt.min+time.Second {
+			t.Errorf("delay(%d) = %v, expected %v plus at most a second of jitter", tt.attempt, d, tt.min)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"cancelled", context.Canceled, false},
+		{"timeout", &stageError{Stage: StageSeparating, TimedOut: true}, false},
+		{"oom kill", &stageError{Stage: StageSeparating, Output: "Killed", Err: errors.New("signal: killed")}, true},
+		{"model download", &stageError{Stage: StageSeparating, Output: "URLError: <urlopen error [Errno -3] Temporary failure in name resolution>"}, true},
+		{"bad input", &stageError{Stage: StageMixing, Output: "song.wav: Invalid data found when processing input"}, false},
+		{"private video", &stageError{Stage: StageDownloading, Output: "ERROR: [youtube] abc: Private video"}, false},
+		{"not a stage error", errors.New("disk full"), false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("%s: isRetryable() = %v, expected %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRetryStage(t *testing.T) {
+	saved := stageRetryPolicies[StageMixing]
+	stageRetryPolicies[StageMixing] = retryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	defer func() { stageRetryPolicies[StageMixing] = saved }()
+
+	transient := &stageError{Stage: StageMixing, Output: "Cannot allocate memory"}
+
+	calls := 0
+	err := retryStage(context.Background(), StageMixing, func(n int) error {
+		calls++
+		if n < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = retryStage(context.Background(), StageMixing, func(n int) error {
+		calls++
+		return &stageError{Stage: StageMixing, Output: "Invalid data found when processing input"}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("Expected a permanent failure to stop after one attempt, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = retryStage(context.Background(), StageMixing, func(n int) error {
+		calls++
+		return transient
+	})
+	if !errors.Is(err, transient) || calls != 3 {
+		t.Errorf("Expected the last error after 3 attempts, got %v after %d calls", err, calls)
+	}
+}
+
+func TestRetryJobNotFound(t *testing.T) {
+	srv := setupTestDB(t)
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("POST", "/api/jobs/non-existent-id/retry", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status code %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestRetryJobConflicts(t *testing.T) {
+	srv := setupTestDB(t)
+
+	completed, _ := srv.jobs.create("test-retry-completed", JobKindYoutube, JobInput{URL: "https://youtu.be/x"})
+	srv.jobs.update(completed, func(j *Job) { j.Status = JobCompleted })
+
+	missing, _ := srv.jobs.create("test-retry-missing-upload", JobKindUpload, JobInput{Original: "uploads/does-not-exist.mp3"})
+	srv.jobs.update(missing, func(j *Job) { j.Status = JobFailed })
+
+	router := setupServerRouter(srv)
+	for _, id := range []string{completed.ID, missing.ID} {
+		req, _ := http.NewRequest("POST", "/api/jobs/"+id+"/retry", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusConflict {
+			t.Errorf("%s: expected status code %d, got %d", id, http.StatusConflict, w.Code)
+		}
+	}
+}
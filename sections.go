@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// parseTimestamp reads a position in a video given as seconds ("95",
+// "95.5"), minutes and seconds ("1:35") or hours, minutes and seconds
+// ("1:01:35").
+func parseTimestamp(value string) (float64, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) > 3 || parts[0] == "" {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 || math.IsInf(n, 0) || math.IsNaN(n) {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		// Only the first field may exceed 59 and only the last may have a
+		// fraction
+		if i > 0 && n >= 60 || i < len(parts)-1 && n != math.Trunc(n) {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return seconds, nil
+}
+
+// formatTimestamp formats seconds as m:ss or h:mm:ss.
+func formatTimestamp(seconds float64) string {
+	total := int(seconds)
+	h, m, s := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
+
+// parseSection validates optional start and end timestamps. An end of 0
+// means the end of the video.
+func parseSection(start, end string) (float64, float64, error) {
+	var from, to float64
+	var err error
+	if start != "" {
+		if from, err = parseTimestamp(start); err != nil {
+			return 0, 0, err
+		}
+	}
+	if end != "" {
+		if to, err = parseTimestamp(end); err != nil {
+			return 0, 0, err
+		}
+		if to <= from {
+			return 0, 0, errors.New("end must be after start")
+		}
+	}
+	return from, to, nil
+}
+
+// sectionArgs returns the yt-dlp arguments that limit a download to the
+// section from start to end, or none for the whole video.
+func sectionArgs(start, end float64) []string {
+	if start == 0 && end == 0 {
+		return nil
+	}
+
+	to := "inf"
+	if end > 0 {
+		to = strconv.FormatFloat(end, 'f', -1, 64)
+	}
+	return []string{
+		"--download-sections", fmt.Sprintf("*%s-%s", strconv.FormatFloat(start, 'f', -1, 64), to),
+		// Cut exactly at the requested times rather than the nearest keyframe
+		"--force-keyframes-at-cuts",
+	}
+}
+
+// sectionLabel describes a section for song names, e.g. "12:30-17:45".
+func sectionLabel(start, end float64) string {
+	if end == 0 {
+		return formatTimestamp(start) + "-end"
+	}
+	return formatTimestamp(start) + "-" + formatTimestamp(end)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := map[string]float64{
+		"0":          0,
+		"95":         95,
+		"95.5":       95.5,
+		"1:35":       95,
+		"12:30":      750,
+		"1:01:35":    3695,
+		"1:01:35.25": 3695.25,
+		"90:00":      5400,
+	}
+	for value, expected := range tests {
+		seconds, err := parseTimestamp(value)
+		if err != nil || seconds != expected {
+			t.Errorf("parseTimestamp(%q) = %v, %v; expected %v", value, seconds, err, expected)
+		}
+	}
+
+	for _, value := range []string{"", "abc", "-5", "1:60", "1:2:3:4", "1.5:00", ":30", "1:"} {
+		if _, err := parseTimestamp(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestParseSection(t *testing.T) {
+	start, end, err := parseSection("12:30", "17:45")
+	if err != nil || start != 750 || end != 1065 {
+		t.Errorf("Expected 750-1065, got %v-%v, %v", start, end, err)
+	}
+
+	if _, _, err := parseSection("17:45", "12:30"); err == nil {
+		t.Error("Expected an error when end is before start")
+	}
+	if start, end, err := parseSection("", ""); err != nil || start != 0 || end != 0 {
+		t.Errorf("Expected no section, got %v-%v, %v", start, end, err)
+	}
+}
+
+func TestSectionArgs(t *testing.T) {
+	if args := sectionArgs(0, 0); args != nil {
+		t.Errorf("Expected no arguments for the whole video, got %v", args)
+	}
+
+	expected := []string{"--download-sections", "*750-1065.5", "--force-keyframes-at-cuts"}
+	if args := sectionArgs(750, 1065.5); !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+	if args := sectionArgs(3600, 0); args[1] != "*3600-inf" {
+		t.Errorf("Expected an open-ended section, got %v", args)
+	}
+}
+
+func TestSectionLabel(t *testing.T) {
+	if label := sectionLabel(750, 1065); label != "12:30-17:45" {
+		t.Errorf("Expected 12:30-17:45, got %q", label)
+	}
+	if label := sectionLabel(3695, 0); label != "1:01:35-end" {
+		t.Errorf("Expected 1:01:35-end, got %q", label)
+	}
+}
+
+func TestDownloadYoutubeInvalidSection(t *testing.T) {
+	router := setupRouter()
+
+	body, _ := json.Marshal(map[string]string{"url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "start": "10:00", "end": "5:00"})
+	req, _ := http.NewRequest("POST", "/api/youtube", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spleeter's highest fidelity 5-stem model, used unless another is
+// configured
+const defaultSpleeterModel = "spleeter:5stems-16kHz"
+
+const (
+	// Loading TensorFlow and the model can take a while on a cold container
+	workerStartTimeout = 5 * time.Minute
+	workerPingInterval = 30 * time.Second
+	workerPingTimeout  = 10 * time.Second
+
+	// After this many failed starts in a row the worker is left alone for
+	// workerCooldown and songs are separated with the spleeter CLI.
+	maxWorkerStartFailures = 3
+	workerCooldown         = 5 * time.Minute
+)
+
+// errWorkerUnavailable means the worker could not take a request at all, as
+// opposed to failing while separating. Callers fall back to the CLI.
+var errWorkerUnavailable = errors.New("separation worker unavailable")
+
+// The persistent worker, or nil when it is disabled.
+var spleeterWorker *separatorWorker
+
+type workerRequest struct {
+	ID     string   `json:"id"`
+	Cmd    string   `json:"cmd"`
+	Inputs []string `json:"inputs,omitempty"`
+	Output string   `json:"output,omitempty"`
+}
+
+type workerResponse struct {
+	ID    string `json:"id,omitempty"`
+	Event string `json:"event,omitempty"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// workerProcess is one running instance of the worker.
+type workerProcess struct {
+	cmd       *exec.Cmd
+	kill      context.CancelFunc
+	stdin     io.WriteCloser
+	stderr    *outputTail
+	responses chan workerResponse
+	exited    chan struct{}
+}
+
+// separatorWorker manages a long-lived Python process (worker/separator.py)
+// that keeps the Spleeter model loaded between songs. Requests and responses
+// are JSON lines over the process's stdin and stdout, one request at a time.
+// The process is started on demand, health-checked while idle and replaced
+// when it crashes or stops answering.
+type separatorWorker struct {
+	command []string
+
+	// Held for the duration of each request
+	sem chan struct{}
+
+	mu            sync.Mutex
+	proc          *workerProcess
+	nextID        int
+	startFailures int
+	disabledUntil time.Time
+}
+
+func newSeparatorWorker(command ...string) *separatorWorker {
+	return &separatorWorker{
+		command: command,
+		sem:     make(chan struct{}, 1),
+	}
+}
+
+// startSeparatorWorker sets up the persistent worker and warms it up in the
+// background, unless the worker is disabled in the configuration.
+func startSeparatorWorker() {
+	if config.Separation.Worker == "disabled" {
+		log.Println("Separation worker disabled, using the spleeter CLI")
+		return
+	}
+
+	spleeterWorker = newSeparatorWorker("python3", config.Separation.WorkerScript, config.Separation.Model)
+	go spleeterWorker.monitor(workerPingInterval)
+}
+
+// start launches a worker process and waits for it to report that the model
+// is loaded.
+func (w *separatorWorker) start(ctx context.Context) (*workerProcess, error) {
+	procCtx, kill := context.WithCancel(context.Background())
+	cmd := commandContext(procCtx, w.command[0], w.command[1:]...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		kill()
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		kill()
+		return nil, err
+	}
+	stderr := &outputTail{}
+	cmd.Stderr = stderr
+
+	if err := cmd.Start(); err != nil {
+		kill()
+		return nil, err
+	}
+
+	p := &workerProcess{
+		cmd:       cmd,
+		kill:      kill,
+		stdin:     stdin,
+		stderr:    stderr,
+		responses: make(chan workerResponse),
+		exited:    make(chan struct{}),
+	}
+	go p.readResponses(procCtx, stdout)
+
+	timer := time.NewTimer(workerStartTimeout)
+	defer timer.Stop()
+
+	select {
+	case resp := <-p.responses:
+		if resp.Event != "ready" {
+			kill()
+			return nil, fmt.Errorf("unexpected first message from worker: %+v", resp)
+		}
+		log.Printf("Separation worker started (pid %d)", cmd.Process.Pid)
+		return p, nil
+	case <-p.exited:
+		kill()
+		return nil, fmt.Errorf("worker exited during startup: %s", stderr.String())
+	case <-timer.C:
+		kill()
+		return nil, fmt.Errorf("worker not ready after %v", workerStartTimeout)
+	case <-ctx.Done():
+		kill()
+		return nil, ctx.Err()
+	}
+}
+
+// readResponses forwards each JSON line the worker prints until it exits.
+func (p *workerProcess) readResponses(ctx context.Context, stdout io.Reader) {
+	defer close(p.exited)
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var resp workerResponse
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			log.Printf("Ignoring malformed line from separation worker: %s", scanner.Text())
+			continue
+		}
+		select {
+		case p.responses <- resp:
+		case <-ctx.Done():
+		}
+	}
+	p.cmd.Wait()
+}
+
+// process returns the running worker, starting a new one if there is none or
+// the previous one has exited.
+func (w *separatorWorker) process(ctx context.Context) (*workerProcess, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.proc != nil {
+		select {
+		case <-w.proc.exited:
+			log.Printf("Separation worker exited unexpectedly: %s", w.proc.stderr.String())
+			w.proc = nil
+		default:
+			return w.proc, nil
+		}
+	}
+
+	if time.Now().Before(w.disabledUntil) {
+		return nil, errWorkerUnavailable
+	}
+
+	p, err := w.start(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		w.startFailures++
+		if w.startFailures >= maxWorkerStartFailures {
+			log.Printf("Separation worker failed to start %d times, using the spleeter CLI for %v", w.startFailures, workerCooldown)
+			w.disabledUntil = time.Now().Add(workerCooldown)
+			w.startFailures = 0
+		}
+		return nil, fmt.Errorf("%w: %v", errWorkerUnavailable, err)
+	}
+
+	w.startFailures = 0
+	w.proc = p
+	return p, nil
+}
+
+// stop kills p and forgets it, so that the next request starts a new worker.
+func (w *separatorWorker) stop(p *workerProcess) {
+	p.kill()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.proc == p {
+		w.proc = nil
+	}
+}
+
+// roundTrip sends req to the worker and waits for its answer. The caller must
+// hold w.sem. The worker cannot abandon a request part way through, so it
+// is killed if ctx is done first.
+func (w *separatorWorker) roundTrip(ctx context.Context, req workerRequest) (workerResponse, error) {
+	p, err := w.process(ctx)
+	if err != nil {
+		return workerResponse{}, err
+	}
+
+	w.mu.Lock()
+	w.nextID++
+	req.ID = strconv.Itoa(w.nextID)
+	w.mu.Unlock()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return workerResponse{}, err
+	}
+	if _, err := p.stdin.Write(append(line, '\n')); err != nil {
+		w.stop(p)
+		return workerResponse{}, fmt.Errorf("%w: %v", errWorkerUnavailable, err)
+	}
+
+	for {
+		select {
+		case resp := <-p.responses:
+			if resp.ID != req.ID {
+				// Left over from an earlier request
+				continue
+			}
+			return resp, nil
+		case <-p.exited:
+			w.stop(p)
+			return workerResponse{}, &stageError{Stage: StageSeparating, Output: p.stderr.String(), Err: errors.New("separation worker exited")}
+		case <-ctx.Done():
+			w.stop(p)
+			return workerResponse{}, &stageError{Stage: StageSeparating, Output: p.stderr.String(), Err: ctx.Err()}
+		}
+	}
+}
+
+// separate runs Spleeter on inputs, writing the stems of each below
+// outputDir in the same layout as the spleeter CLI. The time limit starts
+// once the worker is free, not while waiting for an earlier song.
+func (w *separatorWorker) separate(ctx context.Context, inputs []string, outputDir string, limit time.Duration) error {
+	select {
+	case w.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-w.sem }()
+
+	stageCtx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+
+	resp, err := w.roundTrip(stageCtx, workerRequest{Cmd: "separate", Inputs: inputs, Output: outputDir})
+	var serr *stageError
+	if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) && errors.As(err, &serr) {
+		serr.TimedOut = true
+		serr.Limit = limit
+	}
+	if err != nil {
+		return err
+	}
+	if !resp.OK {
+		return &stageError{Stage: StageSeparating, Output: resp.Error, Err: errors.New("worker could not separate the audio")}
+	}
+	return nil
+}
+
+// checkHealth pings the worker, starting it if it is not running. It does
+// nothing while a song is being separated.
+func (w *separatorWorker) checkHealth() error {
+	select {
+	case w.sem <- struct{}{}:
+	default:
+		return nil
+	}
+	defer func() { <-w.sem }()
+
+	if _, err := w.process(context.Background()); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), workerPingTimeout)
+	defer cancel()
+
+	resp, err := w.roundTrip(ctx, workerRequest{Cmd: "ping"})
+	if err == nil && !resp.OK {
+		err = errors.New(resp.Error)
+	}
+	return err
+}
+
+// monitor warms the worker up straight away and then checks its health every
+// interval, replacing it if it has died or stopped answering.
+func (w *separatorWorker) monitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := w.checkHealth(); err != nil {
+			log.Printf("Separation worker health check failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// stemsDirName returns the name of the directory the stems of input are
+// written to: like Spleeter, its file name without the extension.
+func stemsDirName(input string) string {
+	name := filepath.Base(input)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// separateFiles splits each of inputs into stems below outputDir in one
+// Spleeter run. It uses the persistent worker when there is one and falls
+// back to the spleeter CLI if the worker cannot be used.
+func separateFiles(ctx context.Context, inputs []string, outputDir string, limit time.Duration) error {
+	if spleeterWorker != nil {
+		setStage(ctx, StageSeparating)
+
+		err := spleeterWorker.separate(ctx, inputs, outputDir, limit)
+		if !errors.Is(err, errWorkerUnavailable) {
+			return err
+		}
+		log.Printf("Falling back to the spleeter CLI: %v", err)
+	}
+
+	args := []string{"separate", "-p", config.Separation.Model, "-o", outputDir}
+	return runStage(ctx, StageSeparating, limit, "spleeter", append(args, inputs...)...)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeWorkerCommand runs this test binary as a stand-in for
+// worker/separator.py; see TestFakeSeparationWorker.
+func fakeWorkerCommand(t *testing.T) []string {
+	t.Setenv("DRUMMER_FAKE_WORKER", "1")
+	return []string{os.Args[0], "-test.run=^TestFakeSeparationWorker$"}
+}
+
+// TestFakeSeparationWorker is not a real test. It speaks the worker protocol
+// when run by fakeWorkerCommand: inputs containing "crash" make it exit and
+// inputs containing "hang" make it stop answering.
+func TestFakeSeparationWorker(t *testing.T) {
+	if os.Getenv("DRUMMER_FAKE_WORKER") != "1" {
+		return
+	}
+
+	fmt.Println(`{"event": "ready"}`)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		var req workerRequest
+		json.Unmarshal(scanner.Bytes(), &req)
+
+		resp := workerResponse{ID: req.ID, OK: true}
+		for _, input := range req.Inputs {
+			switch {
+			case strings.Contains(input, "crash"):
+				fmt.Fprintln(os.Stderr, "Killed")
+				os.Exit(137)
+			case strings.Contains(input, "hang"):
+				time.Sleep(time.Minute)
+			}
+
+			dir := filepath.Join(req.Output, strings.TrimSuffix(filepath.Base(input), filepath.Ext(input)))
+			os.MkdirAll(dir, 0755)
+			for _, stem := range []string{"vocals", "drums", "bass", "piano", "other"} {
+				os.WriteFile(filepath.Join(dir, stem+".wav"), []byte(stem), 0644)
+			}
+		}
+
+		line, _ := json.Marshal(resp)
+		fmt.Println(string(line))
+	}
+	os.Exit(0)
+}
+
+func TestSeparatorWorkerSeparate(t *testing.T) {
+	worker := newSeparatorWorker(fakeWorkerCommand(t)...)
+	outputDir := t.TempDir()
+
+	if err := worker.checkHealth(); err != nil {
+		t.Fatalf("Expected a healthy worker, got %v", err)
+	}
+
+	err := worker.separate(context.Background(), []string{"uploads/song.mp3"}, outputDir, time.Minute)
+	if err != nil {
+		t.Fatalf("separate failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "song", "vocals.wav")); err != nil {
+		t.Errorf("Expected stems in the spleeter CLI layout: %v", err)
+	}
+}
+
+func TestSeparatorWorkerRestartsAfterCrash(t *testing.T) {
+	worker := newSeparatorWorker(fakeWorkerCommand(t)...)
+	outputDir := t.TempDir()
+
+	err := worker.separate(context.Background(), []string{"crash.mp3"}, outputDir, time.Minute)
+	var serr *stageError
+	if !errors.As(err, &serr) || !strings.Contains(serr.Output, "Killed") {
+		t.Fatalf("Expected a stage error with the worker's stderr, got %v", err)
+	}
+	if !isRetryable(err) {
+		t.Error("Expected a worker crash to be retryable")
+	}
+
+	err = worker.separate(context.Background(), []string{"song.mp3"}, outputDir, time.Minute)
+	if err != nil {
+		t.Fatalf("Expected a new worker to be started, got %v", err)
+	}
+}
+
+func TestSeparatorWorkerTimeout(t *testing.T) {
+	worker := newSeparatorWorker(fakeWorkerCommand(t)...)
+	outputDir := t.TempDir()
+
+	if err := worker.checkHealth(); err != nil {
+		t.Fatalf("Expected a healthy worker, got %v", err)
+	}
+
+	start := time.Now()
+	err := worker.separate(context.Background(), []string{"hang.mp3"}, outputDir, 200*time.Millisecond)
+	if !isTimeout(err) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the hung worker to be killed promptly, took %v", elapsed)
+	}
+
+	if err := worker.checkHealth(); err != nil {
+		t.Errorf("Expected the hung worker to be replaced, got %v", err)
+	}
+}
+
+func TestSeparatorWorkerUnavailable(t *testing.T) {
+	worker := newSeparatorWorker(filepath.Join(t.TempDir(), "missing-python"))
+
+	err := worker.separate(context.Background(), []string{"song.mp3"}, t.TempDir(), time.Minute)
+	if !errors.Is(err, errWorkerUnavailable) {
+		t.Fatalf("Expected errWorkerUnavailable so callers fall back to the CLI, got %v", err)
+	}
+}
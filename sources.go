@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Source describes where a song was downloaded from.
+type Source struct {
+	URL        string    `json:"url"`
+	VideoID    string    `json:"video_id"`
+	Uploader   string    `json:"uploader,omitempty"`
+	UploadDate string    `json:"upload_date,omitempty"`
+	Duration   float64   `json:"duration,omitempty"`
+	Thumbnail  string    `json:"thumbnail,omitempty"`
+	Chapters   []Chapter `json:"chapters,omitempty"`
+	// The part of the video that was downloaded, in seconds, if not all of it
+	SectionStart float64 `json:"section_start,omitempty"`
+	SectionEnd   float64 `json:"section_end,omitempty"`
+}
+
+// Chapter is a titled section of a video, in seconds from the start.
+type Chapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+// videoInfo is the part of yt-dlp's --dump-json output that we keep.
+type videoInfo struct {
+	ID         string    `json:"id"`
+	Title      string    `json:"title"`
+	WebpageURL string    `json:"webpage_url"`
+	Uploader   string    `json:"uploader"`
+	UploadDate string    `json:"upload_date"`
+	Duration   float64   `json:"duration"`
+	Thumbnail  string    `json:"thumbnail"`
+	Chapters   []Chapter `json:"chapters"`
+}
+
+// parseVideoInfo reads the JSON yt-dlp prints for a video. Anything else on
+// stdout is skipped; the last JSON object wins.
+func parseVideoInfo(output []byte) (*videoInfo, error) {
+	var info *videoInfo
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "{") {
+			continue
+		}
+		var v videoInfo
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			return nil, fmt.Errorf("invalid yt-dlp metadata: %w", err)
+		}
+		info = &v
+	}
+	if info == nil {
+		return nil, errors.New("yt-dlp printed no metadata")
+	}
+	return info, nil
+}
+
+// songName turns the video title into a song name, or a placeholder when
+// there is none.
+func (v *videoInfo) songName() string {
+	if v == nil || strings.TrimSpace(v.Title) == "" {
+		return "YouTube Video"
+	}
+
+	return cleanSongName(v.Title)
+}
+
+// cleanSongName makes a title safe to use as a song name and file name.
+func cleanSongName(title string) string {
+	name := strings.TrimSpace(title)
+	name = strings.ReplaceAll(name, "/", "-")
+	name = strings.ReplaceAll(name, "\\", "-")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
+
+// source returns the details worth keeping about the video at url.
+func (v *videoInfo) source(url string) *Source {
+	src := &Source{
+		URL:        url,
+		VideoID:    v.ID,
+		Uploader:   v.Uploader,
+		UploadDate: v.UploadDate,
+		Duration:   v.Duration,
+		Thumbnail:  v.Thumbnail,
+		Chapters:   v.Chapters,
+	}
+	if v.WebpageURL != "" {
+		src.URL = v.WebpageURL
+	}
+	// yt-dlp reports dates as YYYYMMDD
+	if t, err := time.Parse("20060102", v.UploadDate); err == nil {
+		src.UploadDate = t.Format("2006-01-02")
+	}
+	return src
+}
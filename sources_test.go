@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testVideoJSON = `{"id": "dQw4w9WgXcQ", "title": "Live at the Roundhouse / Full Set", "webpage_url": "https://www.youtube.com/watch?v=dQw4w9WgXcQ", "uploader": "The Band", "upload_date": "20230115", "duration": 3725.5, "thumbnail": "https://i.ytimg.com/vi/dQw4w9WgXcQ/maxresdefault.jpg", "chapters": [{"title": "Intro", "start_time": 0, "end_time": 95.0}, {"title": "Opener", "start_time": 95.0, "end_time": 3725.5}], "formats": [{"format_id": "251"}]}`
+
+func TestParseVideoInfo(t *testing.T) {
+	output := "[debug] not json\n" + testVideoJSON + "\n"
+
+	info, err := parseVideoInfo([]byte(output))
+	if err != nil {
+		t.Fatalf("Failed to parse metadata: %v", err)
+	}
+	if info.ID != "dQw4w9WgXcQ" || info.Uploader != "The Band" || info.Duration != 3725.5 {
+		t.Errorf("Unexpected metadata: %+v", info)
+	}
+	if len(info.Chapters) != 2 || info.Chapters[1].Title != "Opener" || info.Chapters[1].StartTime != 95 {
+		t.Errorf("Unexpected chapters: %+v", info.Chapters)
+	}
+
+	if name := info.songName(); name != "Live at the Roundhouse - Full Set" {
+		t.Errorf("Expected a filesystem-safe name, got %q", name)
+	}
+
+	src := info.source("https://youtu.be/dQw4w9WgXcQ")
+	if src.URL != "https://www.youtube.com/watch?v=dQw4w9WgXcQ" {
+		t.Errorf("Expected the canonical URL, got %q", src.URL)
+	}
+	if src.UploadDate != "2023-01-15" {
+		t.Errorf("Expected upload date 2023-01-15, got %q", src.UploadDate)
+	}
+}
+
+func TestParseVideoInfoErrors(t *testing.T) {
+	if _, err := parseVideoInfo([]byte("")); err == nil {
+		t.Error("Expected an error for empty output")
+	}
+	if _, err := parseVideoInfo([]byte("{not json")); err == nil {
+		t.Error("Expected an error for malformed JSON")
+	}
+
+	var info *videoInfo
+	if name := info.songName(); name != "YouTube Video" {
+		t.Errorf("Expected the placeholder name without metadata, got %q", name)
+	}
+	long := &videoInfo{Title: strings.Repeat("a", 150)}
+	if name := long.songName(); len(name) != 100 {
+		t.Errorf("Expected the name to be trimmed to 100 characters, got %d", len(name))
+	}
+}
+
+func TestGetSongsIncludesSource(t *testing.T) {
+	srv := setupTestDB(t)
+
+	info, _ := parseVideoInfo([]byte(testVideoJSON))
+	downloaded := &Song{ID: "downloaded", Name: info.songName(), Original: "uploads/downloaded.mp3", Processed: "processed/downloaded.mp3", CreatedAt: time.Now()}
+	uploaded := &Song{ID: "uploaded", Name: "Uploaded", Original: "uploads/uploaded.mp3", Processed: "processed/uploaded.mp3", CreatedAt: time.Now().Add(-time.Hour)}
+	srv.songs.SaveSong(context.Background(), downloaded)
+	srv.songs.SaveSong(context.Background(), uploaded)
+	if err := srv.songs.SaveSource(context.Background(), downloaded.ID, info.source("https://youtu.be/dQw4w9WgXcQ")); err != nil {
+		t.Fatalf("Failed to save source: %v", err)
+	}
+
+	router := setupServerRouter(srv)
+	req, _ := http.NewRequest("GET", "/api/songs", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var songs []Song
+	if err := json.Unmarshal(w.Body.Bytes(), &songs); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(songs) != 2 {
+		t.Fatalf("Expected 2 songs, got %d", len(songs))
+	}
+	if songs[0].Source == nil || songs[0].Source.VideoID != "dQw4w9WgXcQ" || len(songs[0].Source.Chapters) != 2 {
+		t.Errorf("Expected the downloaded song to include its source, got %+v", songs[0].Source)
+	}
+	if songs[1].Source != nil {
+		t.Errorf("Expected no source for an uploaded song, got %+v", songs[1].Source)
+	}
+
+	// Deleting the song removes its source
+	if err := srv.songs.DeleteSong(context.Background(), downloaded.ID); err != nil {
+		t.Fatal(err)
+	}
+	srv.songs.SaveSong(context.Background(), downloaded)
+	if got, err := srv.songs.GetSong(context.Background(), downloaded.ID); err != nil || got.Source != nil {
+		t.Errorf("Expected the source to be deleted, got %+v, %v", got, err)
+	}
+}
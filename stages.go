@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	StageDownloading = "downloading"
+	StageSeparating  = "separating"
+	StageMixing      = "mixing"
+)
+
+// Captured tool output is trimmed to this many bytes, keeping the end where
+// the actual error usually is.
+const maxStageOutput = 4096
+
+// stageLimit is the time allowed for one processing stage: Base plus
+// PerMinute for every minute of input audio.
+type stageLimit struct {
+	Base      time.Duration
+	PerMinute time.Duration
+}
+
+func (l stageLimit) forDuration(audio time.Duration) time.Duration {
+	return l.Base + time.Duration(audio.Minutes()*float64(l.PerMinute))
+}
+
+// Defaults suit a CPU-only container; override them in the timeouts
+// section of the configuration. Downloads are limited per attempt.
+var stageLimits = map[string]stageLimit{
+	StageDownloading: {Base: 10 * time.Minute},
+	StageSeparating:  {Base: 5 * time.Minute, PerMinute: 2 * time.Minute},
+	StageMixing:      {Base: 2 * time.Minute, PerMinute: 15 * time.Second},
+}
+
+// loadStageLimits applies the configured timeouts to stageLimits.
+func loadStageLimits() {
+	t := config.Timeouts
+	stageLimits[StageDownloading] = stageLimit{Base: t.Download}
+	stageLimits[StageSeparating] = stageLimit{Base: t.Separation, PerMinute: t.SeparationPerMinute}
+	stageLimits[StageMixing] = stageLimit{Base: t.Mixing, PerMinute: t.MixingPerMinute}
+}
+
+// stageError describes an external tool that failed or ran out of time
+// during a processing stage, along with the tail of what it printed.
+type stageError struct {
+	Stage    string
+	TimedOut bool
+	Limit    time.Duration
+	Output   string
+	Err      error
+}
+
+func (e *stageError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("%s timed out after %v", e.Stage, e.Limit)
+	}
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+func (e *stageError) Unwrap() error {
+	return e.Err
+}
+
+// isTimeout reports whether err was caused by a stage running out of time.
+func isTimeout(err error) bool {
+	var serr *stageError
+	return errors.As(err, &serr) && serr.TimedOut
+}
+
+// runStage runs an external tool for one stage of the job under ctx, killing
+// it once limit has passed.
+func runStage(ctx context.Context, stage string, limit time.Duration, name string, args ...string) error {
+	setStage(ctx, stage)
+
+	stageCtx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+
+	output, err := commandContext(stageCtx, name, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	return newStageError(ctx, stageCtx, stage, limit, tailOutput(output), err)
+}
+
+// runStageOutput is like runStage for tools that print results on stdout.
+// It returns what the tool printed there; stderr is kept for the error.
+func runStageOutput(ctx context.Context, stage string, limit time.Duration, name string, args ...string) ([]byte, error) {
+	setStage(ctx, stage)
+
+	stageCtx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+
+	stderr := &outputTail{}
+	cmd := commandContext(stageCtx, name, args...)
+	cmd.Stderr = stderr
+
+	output, err := cmd.Output()
+	if err == nil {
+		return output, nil
+	}
+	return nil, newStageError(ctx, stageCtx, stage, limit, stderr.String(), err)
+}
+
+func newStageError(ctx, stageCtx context.Context, stage string, limit time.Duration, output string, err error) *stageError {
+	serr := &stageError{Stage: stage, Output: output, Err: err}
+	if ctx.Err() == nil && errors.Is(stageCtx.Err(), context.DeadlineExceeded) {
+		serr.TimedOut = true
+		serr.Limit = limit
+	}
+	return serr
+}
+
+// outputTail is an io.Writer that keeps the last maxStageOutput bytes written
+// to it, for processes whose output is collected while they run.
+type outputTail struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > 2*maxStageOutput {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-maxStageOutput:]...)
+	}
+	return len(p), nil
+}
+
+func (t *outputTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return tailOutput(t.buf)
+}
+
+func tailOutput(output []byte) string {
+	if len(output) > maxStageOutput {
+		output = output[len(output)-maxStageOutput:]
+	}
+	return string(output)
+}
+
+// probeDuration returns the length of an audio file as reported by ffprobe.
+func probeDuration(ctx context.Context, path string) (time.Duration, error) {
+	probeCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	output, err := commandContext(probeCtx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected ffprobe output %q", strings.TrimSpace(string(output)))
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStageLimitForDuration(t *testing.T) {
+	limit := stageLimit{Base: 5 * time.Minute, PerMinute: 2 * time.Minute}
+
+	if got := limit.forDuration(0); got != 5*time.Minute {
+		t.Errorf("Expected base limit for unknown duration, got %v", got)
+	}
+	if got := limit.forDuration(4*time.Minute + 30*time.Second); got != 14*time.Minute {
+		t.Errorf("Expected 14m for a 4m30s song, got %v", got)
+	}
+}
+
+func TestRunStageTimeout(t *testing.T) {
+	srv := setupTestDB(t)
+
+	ctx, job := startTestJob(t, srv.jobs, "test-stage-timeout", JobKindUpload)
+
+	err := runStage(ctx, StageSeparating, 200*time.Millisecond, "sh", "-c", "echo loading model; sleep 30")
+	if !isTimeout(err) {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	srv.jobs.finish(job, err)
+
+	got, _ := srv.jobs.getJobByID("test-stage-timeout")
+	if got.Status != JobFailed {
+		t.Errorf("Expected status '%s', got '%s'", JobFailed, got.Status)
+	}
+	if got.Reason != "timeout" {
+		t.Errorf("Expected reason 'timeout', got '%s'", got.Reason)
+	}
+	if !strings.Contains(got.Output, "loading model") {
+		t.Errorf("Expected captured tool output, got '%s'", got.Output)
+	}
+}
+
+func TestRunStageFailure(t *testing.T) {
+	err := runStage(context.Background(), StageMixing, time.Minute, "sh", "-c", "echo bad input >&2; exit 1")
+
+	var serr *stageError
+	if !errors.As(err, &serr) {
+		t.Fatalf("Expected a stageError, got %v", err)
+	}
+	if serr.TimedOut {
+		t.Error("Expected a failure, not a timeout")
+	}
+	if !strings.Contains(serr.Output, "bad input") {
+		t.Errorf("Expected captured stderr, got '%s'", serr.Output)
+	}
+}
+
+func TestTailOutput(t *testing.T) {
+	output := strings.Repeat("a", maxStageOutput) + "the error"
+	got := tailOutput([]byte(output))
+
+	if len(got) != maxStageOutput {
+		t.Errorf("Expected output trimmed to %d bytes, got %d", maxStageOutput, len(got))
+	}
+	if !strings.HasSuffix(got, "the error") {
+		t.Errorf("Expected the end of the output to be kept")
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SongStore keeps the library: the songs, where they were downloaded from
+// and their markers. Looking up a song that does not exist returns
+// sql.ErrNoRows, whatever the implementation.
+type SongStore interface {
+	SaveSong(ctx context.Context, song *Song) error
+	// GetSong returns a song with its source and markers.
+	GetSong(ctx context.Context, id string) (*Song, error)
+	// GetSongByHash returns the oldest song with the given content hash,
+	// without its source and markers.
+	GetSongByHash(ctx context.Context, hash string) (*Song, error)
+	// ListSongs returns every song with its source and markers, newest
+	// first.
+	ListSongs(ctx context.Context) ([]*Song, error)
+	RenameSong(ctx context.Context, id, name string) error
+	// DeleteSong removes a song with its source and markers. Its files are
+	// left to the caller.
+	DeleteSong(ctx context.Context, id string) error
+	// FileReferences returns how many songs use path as their original or
+	// processed file.
+	FileReferences(ctx context.Context, path string) (int, error)
+	SaveSource(ctx context.Context, songID string, src *Source) error
+	// SaveMarkers replaces the markers of a song.
+	SaveMarkers(ctx context.Context, songID string, markers []Chapter) error
+}
+
+// sqlSongStore is the SongStore of the songs, sources and markers tables, in
+// SQLite or PostgreSQL.
+type sqlSongStore struct {
+	db *database
+}
+
+func newSQLSongStore(db *database) *sqlSongStore {
+	return &sqlSongStore{db: db}
+}
+
+const songColumns = `id, name, original_path, processed_path, content_hash, parent_id, created_at`
+
+func scanSong(row interface{ Scan(...any) error }) (*Song, error) {
+	var song Song
+	err := row.Scan(&song.ID, &song.Name, &song.Original, &song.Processed, &song.ContentHash, &song.ParentID, &song.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &song, nil
+}
+
+func (s *sqlSongStore) SaveSong(ctx context.Context, song *Song) error {
+	query := `INSERT INTO songs (` + songColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := s.db.ExecContext(ctx, s.db.rebind(query), song.ID, song.Name, song.Original, song.Processed, song.ContentHash, song.ParentID, song.CreatedAt)
+	return err
+}
+
+func (s *sqlSongStore) GetSong(ctx context.Context, id string) (*Song, error) {
+	query := `SELECT ` + songColumns + ` FROM songs WHERE id = ?`
+	song, err := scanSong(s.db.QueryRowContext(ctx, s.db.rebind(query), id))
+	if err != nil {
+		return nil, err
+	}
+
+	song.Source, err = s.getSource(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	markers, err := s.queryMarkers(ctx, `SELECT song_id, title, start_time, end_time FROM markers WHERE song_id = ? ORDER BY position`, id)
+	if err != nil {
+		return nil, err
+	}
+	song.Markers = markers[id]
+	return song, nil
+}
+
+func (s *sqlSongStore) GetSongByHash(ctx context.Context, hash string) (*Song, error) {
+	query := `SELECT ` + songColumns + ` FROM songs WHERE content_hash = ? ORDER BY created_at LIMIT 1`
+	return scanSong(s.db.QueryRowContext(ctx, s.db.rebind(query), hash))
+}
+
+func (s *sqlSongStore) ListSongs(ctx context.Context) ([]*Song, error) {
+	query := `SELECT ` + songColumns + ` FROM songs ORDER BY created_at DESC`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var songs []*Song
+	for rows.Next() {
+		song, err := scanSong(rows)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, song)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sources, err := s.getSources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	markers, err := s.queryMarkers(ctx, `SELECT song_id, title, start_time, end_time FROM markers ORDER BY song_id, position`)
+	if err != nil {
+		return nil, err
+	}
+	for _, song := range songs {
+		song.Source = sources[song.ID]
+		song.Markers = markers[song.ID]
+	}
+	return songs, nil
+}
+
+func (s *sqlSongStore) RenameSong(ctx context.Context, id, name string) error {
+	_, err := s.db.ExecContext(ctx, s.db.rebind(`UPDATE songs SET name = ? WHERE id = ?`), name, id)
+	return err
+}
+
+func (s *sqlSongStore) DeleteSong(ctx context.Context, id string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM sources WHERE song_id = ?`,
+		`DELETE FROM markers WHERE song_id = ?`,
+		`DELETE FROM songs WHERE id = ?`,
+	} {
+		if _, err := tx.ExecContext(ctx, s.db.rebind(query), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlSongStore) FileReferences(ctx context.Context, path string) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.db.rebind(`SELECT COUNT(*) FROM songs WHERE original_path = ? OR processed_path = ?`), path, path).Scan(&count)
+	return count, err
+}
+
+const sourceColumns = `url, video_id, uploader, upload_date, duration, thumbnail, chapters, section_start, section_end`
+
+func scanSource(row interface{ Scan(...any) error }, dest ...any) (*Source, error) {
+	var chapters string
+	var src Source
+	fields := append(dest, &src.URL, &src.VideoID, &src.Uploader, &src.UploadDate, &src.Duration, &src.Thumbnail, &chapters, &src.SectionStart, &src.SectionEnd)
+	if err := row.Scan(fields...); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(chapters), &src.Chapters); err != nil {
+		return nil, fmt.Errorf("invalid chapters: %w", err)
+	}
+	return &src, nil
+}
+
+func (s *sqlSongStore) SaveSource(ctx context.Context, songID string, src *Source) error {
+	chapters, err := json.Marshal(src.Chapters)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO sources (song_id, ` + sourceColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (song_id) DO UPDATE SET url = excluded.url, video_id = excluded.video_id,
+			uploader = excluded.uploader, upload_date = excluded.upload_date, duration = excluded.duration,
+			thumbnail = excluded.thumbnail, chapters = excluded.chapters,
+			section_start = excluded.section_start, section_end = excluded.section_end`
+	_, err = s.db.ExecContext(ctx, s.db.rebind(query), songID, src.URL, src.VideoID, src.Uploader, src.UploadDate, src.Duration, src.Thumbnail, string(chapters), src.SectionStart, src.SectionEnd)
+	return err
+}
+
+// getSources returns the source of every song that has one, by song ID.
+func (s *sqlSongStore) getSources(ctx context.Context) (map[string]*Source, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT song_id, `+sourceColumns+` FROM sources`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := make(map[string]*Source)
+	for rows.Next() {
+		var songID string
+		src, err := scanSource(rows, &songID)
+		if err != nil {
+			return nil, err
+		}
+		sources[songID] = src
+	}
+	return sources, rows.Err()
+}
+
+// getSource returns the source of a song, or nil if it has none.
+func (s *sqlSongStore) getSource(ctx context.Context, songID string) (*Source, error) {
+	src, err := scanSource(s.db.QueryRowContext(ctx, s.db.rebind(`SELECT `+sourceColumns+` FROM sources WHERE song_id = ?`), songID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return src, err
+}
+
+func (s *sqlSongStore) SaveMarkers(ctx context.Context, songID string, markers []Chapter) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.db.rebind(`DELETE FROM markers WHERE song_id = ?`), songID); err != nil {
+		return err
+	}
+	for i, m := range markers {
+		_, err := tx.ExecContext(ctx, s.db.rebind(`INSERT INTO markers (song_id, position, title, start_time, end_time) VALUES (?, ?, ?, ?, ?)`),
+			songID, i, m.Title, m.StartTime, m.EndTime)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// queryMarkers returns the markers selected by query, by song ID.
+func (s *sqlSongStore) queryMarkers(ctx context.Context, query string, args ...any) (map[string][]Chapter, error) {
+	rows, err := s.db.QueryContext(ctx, s.db.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	markers := make(map[string][]Chapter)
+	for rows.Next() {
+		var songID string
+		var m Chapter
+		if err := rows.Scan(&songID, &m.Title, &m.StartTime, &m.EndTime); err != nil {
+			return nil, err
+		}
+		markers[songID] = append(markers[songID], m)
+	}
+	return markers, rows.Err()
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// songStores returns a new store of every implementation, by name.
+func songStores(t *testing.T) map[string]SongStore {
+	return map[string]SongStore{
+		"sqlite": setupTestDB(t).songs,
+		"memory": newMemorySongStore(),
+	}
+}
+
+func TestSongStore(t *testing.T) {
+	for name, store := range songStores(t) {
+		t.Run(name, func(t *testing.T) {
+			testSongStore(t, store)
+		})
+	}
+}
+
+// testSongStore checks that an empty store behaves as SongStore documents.
+func testSongStore(t *testing.T, store SongStore) {
+	ctx := context.Background()
+	now := time.Now()
+
+	first := &Song{ID: "first", Name: "First", Original: "uploads/a.mp3", Processed: "processed/a.mp3", ContentHash: "aaa", CreatedAt: now.Add(-time.Hour)}
+	copied := &Song{ID: "copy", Name: "Copy", Original: "uploads/a.mp3", Processed: "processed/a.mp3", ContentHash: "aaa", CreatedAt: now}
+	other := &Song{ID: "other", Name: "Other", Original: "uploads/b.mp3", Processed: "processed/b.mp3", ContentHash: "bbb", CreatedAt: now.Add(-time.Minute)}
+	for _, song := range []*Song{first, copied, other} {
+		if err := store.SaveSong(ctx, song); err != nil {
+			t.Fatalf("Failed to save %s: %v", song.ID, err)
+		}
+	}
+	if err := store.SaveSong(ctx, first); err == nil {
+		t.Error("Expected saving a song twice to fail")
+	}
+
+	if song, err := store.GetSongByHash(ctx, "aaa"); err != nil || song.ID != "first" {
+		t.Errorf("Expected the oldest song with the hash, got %+v, %v", song, err)
+	}
+	if _, err := store.GetSongByHash(ctx, "ccc"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown hash, got %v", err)
+	}
+	if _, err := store.GetSong(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for an unknown song, got %v", err)
+	}
+
+	src := &Source{URL: "https://youtu.be/abc", VideoID: "abc", Chapters: []Chapter{{Title: "Intro", EndTime: 30}}}
+	markers := []Chapter{{Title: "Verse", StartTime: 0, EndTime: 60}, {Title: "Chorus", StartTime: 60, EndTime: 90}}
+	if err := store.SaveSource(ctx, "other", src); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveMarkers(ctx, "other", markers); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.RenameSong(ctx, "other", "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+
+	song, err := store.GetSong(ctx, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Name != "Renamed" || !reflect.DeepEqual(song.Source, src) || !reflect.DeepEqual(song.Markers, markers) {
+		t.Errorf("Expected the renamed song with its source and markers, got %+v", song)
+	}
+
+	songs, err := store.ListSongs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, song := range songs {
+		ids = append(ids, song.ID)
+	}
+	if !reflect.DeepEqual(ids, []string{"copy", "other", "first"}) {
+		t.Errorf("Expected the newest song first, got %v", ids)
+	}
+	if songs[1].Source == nil || len(songs[1].Markers) != 2 || songs[0].Source != nil || songs[0].Markers != nil {
+		t.Errorf("Expected sources and markers only on the song that has them, got %+v", songs)
+	}
+
+	if refs, err := store.FileReferences(ctx, "uploads/a.mp3"); err != nil || refs != 2 {
+		t.Errorf("Expected 2 references to the shared file, got %d, %v", refs, err)
+	}
+
+	// The source and markers go with the song
+	if err := store.DeleteSong(ctx, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetSong(ctx, "other"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected the song to be deleted, got %v", err)
+	}
+	store.SaveSong(ctx, other)
+	if song, err := store.GetSong(ctx, "other"); err != nil || song.Source != nil || song.Markers != nil {
+		t.Errorf("Expected the source and markers to be deleted, got %+v, %v", song, err)
+	}
+	if refs, err := store.FileReferences(ctx, "uploads/b.mp3"); err != nil || refs != 1 {
+		t.Errorf("Expected 1 reference, got %d, %v", refs, err)
+	}
+}
+
+func TestMemorySongStoreReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	store := newMemorySongStore()
+
+	song := &Song{ID: "song", Name: "Song", CreatedAt: time.Now()}
+	store.SaveSong(ctx, song)
+	song.Name = "Changed by the caller"
+
+	got, _ := store.GetSong(ctx, "song")
+	got.Name = "Changed again"
+	if got, _ := store.GetSong(ctx, "song"); got.Name != "Song" {
+		t.Errorf("Expected the stored song to be unchanged, got %q", got.Name)
+	}
+}
+
+func TestServersHaveSeparateLibraries(t *testing.T) {
+	first := newMemorySongStore()
+	second := newMemorySongStore()
+	first.SaveSong(context.Background(), &Song{ID: "only-in-first", Name: "First", CreatedAt: time.Now()})
+
+	for _, test := range []struct {
+		store SongStore
+		count int
+	}{{first, 1}, {second, 0}} {
+		router := setupServerRouter(newServer(nil, test.store))
+		req, _ := http.NewRequest("GET", "/api/songs", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		var songs []Song
+		json.Unmarshal(w.Body.Bytes(), &songs)
+		if w.Code != http.StatusOK || len(songs) != test.count {
+			t.Errorf("Expected %d songs, got %d: %s", test.count, len(songs), w.Body.String())
+		}
+
+		req, _ = http.NewRequest("GET", "/api/download/only-in-first", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if test.count == 0 && w.Code != http.StatusNotFound {
+			t.Errorf("Expected the song of the other server to be missing, got %d", w.Code)
+		}
+	}
+}
+
+func TestServerJobsSaveToItsLibrary(t *testing.T) {
+	srv := setupTestDB(t)
+	fakeFFmpeg(t, 10)
+	fakeSeparations(t)
+	makeDataDirs()
+
+	library := newMemorySongStore()
+	s := newServer(srv.db, library)
+	useTestRenderCache(t, s, 1<<20)
+
+	w := httptest.NewRecorder()
+	setupServerRouter(s).ServeHTTP(w, newUploadRequest(t, "opener.mp3", []byte("opener"), nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	var song Song
+	json.Unmarshal(w.Body.Bytes(), &song)
+	t.Cleanup(func() {
+		os.Remove(song.Original)
+		os.Remove(song.Processed)
+	})
+
+	if _, err := library.GetSong(context.Background(), song.ID); err != nil {
+		t.Errorf("Expected the song to be added to the library of the server: %v", err)
+	}
+	if songs, _ := srv.songs.ListSongs(context.Background()); len(songs) != 0 {
+		t.Errorf("Expected the other library to stay empty, got %d songs", len(songs))
+	}
+}
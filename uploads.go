@@ -0,0 +1,209 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Most files unpacked from one upload archive.
+const maxArchiveEntries = 500
+
+// What happened to one file of a multi-file upload.
+const (
+	UploadQueued    = "queued"
+	UploadDuplicate = "duplicate"
+	UploadSkipped   = "skipped"
+	UploadFailed    = "failed"
+)
+
+// UploadResult reports one file of a multi-file upload. Queued files are
+// processed in the background as jobs of the upload's batch.
+type UploadResult struct {
+	Filename string `json:"filename"`
+	Status   string `json:"status"`
+	JobID    string `json:"job_id,omitempty"`
+	SongID   string `json:"song_id,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+func isArchive(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".zip")
+}
+
+// uploadFiles handles an upload of several files, or of a zip archive of
+// them. Each audio file is queued as its own job in a new batch, and the
+// response reports every file.
+func (s *server) uploadFiles(c *gin.Context, headers []*multipart.FileHeader, duplicate string) {
+	batch := &ImportBatch{
+		ID:        uuid.New().String(),
+		Kind:      BatchKindUpload,
+		CreatedAt: time.Now(),
+	}
+	if len(headers) == 1 {
+		batch.Title = headers[0].Filename
+	}
+	if err := s.jobs.saveImportBatch(batch); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create batch"})
+		return
+	}
+
+	var results []UploadResult
+	var queued []*Job
+	add := func(result UploadResult, job *Job) {
+		results = append(results, result)
+		if job != nil {
+			queued = append(queued, job)
+		}
+	}
+
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			add(UploadResult{Filename: header.Filename, Status: UploadFailed, Error: "Failed to read file"}, nil)
+			continue
+		}
+
+		if isArchive(header.Filename) {
+			err = unpackArchive(file, header.Size, func(name string, r io.Reader) {
+				add(s.jobs.queueUploadFile(c.Request.Context(), batch.ID, name, r, duplicate))
+			})
+			if err != nil {
+				add(UploadResult{Filename: header.Filename, Status: UploadFailed, Error: fmt.Sprintf("Invalid archive: %v", err)}, nil)
+			}
+		} else {
+			add(s.jobs.queueUploadFile(c.Request.Context(), batch.ID, header.Filename, file, duplicate))
+		}
+		file.Close()
+	}
+
+	go s.jobs.runImportBatch(queued)
+
+	status := http.StatusOK
+	if len(queued) > 0 {
+		status = http.StatusAccepted
+	}
+	c.JSON(status, gin.H{"batch_id": batch.ID, "files": results})
+}
+
+// unpackArchive calls fn for every file in the zip archive r, skipping
+// directories and the metadata macOS adds. The files may hold no more than
+// maxResumableSize in total once unpacked.
+func unpackArchive(r io.ReaderAt, size int64, fn func(name string, r io.Reader)) error {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	var entries []*zip.File
+	for _, f := range archive.File {
+		name := path.Base(f.Name)
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(name, ".") {
+			continue
+		}
+		entries = append(entries, f)
+	}
+	if len(entries) > maxArchiveEntries {
+		return fmt.Errorf("more than %d files", maxArchiveEntries)
+	}
+
+	remaining := maxResumableSize
+	for _, f := range entries {
+		if f.UncompressedSize64 > uint64(remaining) {
+			return errors.New("the unpacked files are too large")
+		}
+		remaining -= int64(f.UncompressedSize64)
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		// The declared size is checked while reading, too
+		fn(path.Base(f.Name), io.LimitReader(rc, int64(f.UncompressedSize64)))
+		rc.Close()
+	}
+	return nil
+}
+
+// queueUploadFile stores one uploaded audio file and queues a job for it in
+// the batch, unless the library already has it. Files other than MP3 are
+// converted when the job runs.
+func (r *jobRegistry) queueUploadFile(ctx context.Context, batchID, filename string, body io.Reader, duplicate string) (UploadResult, *Job) {
+	result := UploadResult{Filename: filename}
+
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !audioExtensions[ext] {
+		result.Status = UploadSkipped
+		result.Error = "Not a supported audio file"
+		return result, nil
+	}
+
+	id := uuid.New().String()
+	input := JobInput{
+		Filename:  strings.TrimSuffix(filename, filepath.Ext(filename)) + ".mp3",
+		Title:     filename,
+		Original:  filepath.Join(config.Paths.Uploads, id+".mp3"),
+		Processed: filepath.Join(config.Paths.Processed, id+".mp3"),
+	}
+	received := input.Original
+	if ext != ".mp3" {
+		input.Upload = filepath.Join(config.Paths.Uploads, id+ext)
+		received = input.Upload
+	}
+
+	fail := func(message string, err error) (UploadResult, *Job) {
+		log.Printf("Failed to upload %s: %v", filename, err)
+		os.Remove(received)
+		result.Status = UploadFailed
+		result.Error = message
+		return result, nil
+	}
+
+	dst, err := os.Create(received)
+	if err != nil {
+		return fail("Failed to save file", err)
+	}
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hash), body)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fail("Failed to save file", err)
+	}
+	input.ContentHash = hex.EncodeToString(hash.Sum(nil))
+
+	song, err := findDuplicate(ctx, r.songs, input.ContentHash, duplicate, input.Filename)
+	if err != nil {
+		return fail("Failed to save song metadata", err)
+	}
+	if song != nil {
+		os.Remove(received)
+		result.Status = UploadDuplicate
+		result.SongID = song.ID
+		return result, nil
+	}
+
+	job, err := r.createInBatch(batchID, id, JobKindUpload, input)
+	if err != nil {
+		return fail("Failed to create job", err)
+	}
+	result.Status = UploadQueued
+	result.JobID = job.ID
+	return result, job
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// newMultiUploadRequest builds an upload with one "file" part per entry of
+// files, in order.
+func newMultiUploadRequest(t *testing.T, files [][2]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, f := range files {
+		part, err := w.CreateFormFile("file", f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(f[1]))
+	}
+	w.Close()
+
+	req, _ := http.NewRequest("POST", "/api/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func newZip(t *testing.T, files [][2]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range files {
+		part, err := w.Create(f[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(f[1]))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+type multiUploadResponse struct {
+	BatchID string         `json:"batch_id"`
+	Files   []UploadResult `json:"files"`
+}
+
+func TestUploadMultipleFiles(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	first := saveHashedSong(t, srv.songs, "first", []byte("first song"))
+	second := saveHashedSong(t, srv.songs, "second", []byte("second song"))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newMultiUploadRequest(t, [][2]string{
+		{"01 First.mp3", "first song"},
+		{"notes.txt", "not audio"},
+		{"02 Second.mp3", "second song"},
+	}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp multiUploadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.BatchID == "" || len(resp.Files) != 3 {
+		t.Fatalf("Expected a batch with 3 results, got %+v", resp)
+	}
+
+	want := []UploadResult{
+		{Filename: "01 First.mp3", Status: UploadDuplicate, SongID: first.ID},
+		{Filename: "notes.txt", Status: UploadSkipped},
+		{Filename: "02 Second.mp3", Status: UploadDuplicate, SongID: second.ID},
+	}
+	for i, result := range resp.Files {
+		if result.Filename != want[i].Filename || result.Status != want[i].Status || result.SongID != want[i].SongID {
+			t.Errorf("Expected %+v, got %+v", want[i], result)
+		}
+	}
+
+	batch, err := srv.jobs.getImportBatchByID(resp.BatchID)
+	if err != nil || batch.Kind != BatchKindUpload {
+		t.Errorf("Expected an upload batch, got %+v, %v", batch, err)
+	}
+}
+
+func TestUploadSingleFileToConvert(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	song := saveHashedSong(t, srv.songs, "live", []byte("flac data"))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newMultiUploadRequest(t, [][2]string{{"Live.flac", "flac data"}}))
+	var resp multiUploadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || len(resp.Files) != 1 || resp.Files[0].Status != UploadDuplicate || resp.Files[0].SongID != song.ID {
+		t.Errorf("Expected the FLAC file to go through the batch upload, got %d: %s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newMultiUploadRequest(t, [][2]string{{"notes.txt", "not audio"}}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a file that is not audio, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestUploadArchive(t *testing.T) {
+	srv := setupTestDB(t)
+	router := setupServerRouter(srv)
+
+	existing := saveHashedSong(t, srv.songs, "existing", []byte("album track"))
+	archive := newZip(t, [][2]string{
+		{"Album/", ""},
+		{"Album/01 Track.mp3", "album track"},
+		{"Album/cover.jpg", "image"},
+		{"Album/.DS_Store", "junk"},
+		{"__MACOSX/Album/._01 Track.mp3", "junk"},
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequest(t, "Album.zip", archive, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp multiUploadResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 2 {
+		t.Fatalf("Expected the track and the cover to be reported, got %+v", resp.Files)
+	}
+	if resp.Files[0].Filename != "01 Track.mp3" || resp.Files[0].SongID != existing.ID {
+		t.Errorf("Expected the track to match the existing song, got %+v", resp.Files[0])
+	}
+	if resp.Files[1].Filename != "cover.jpg" || resp.Files[1].Status != UploadSkipped {
+		t.Errorf("Expected the cover to be skipped, got %+v", resp.Files[1])
+	}
+
+	// A broken archive is reported as a failed file
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequest(t, "Broken.zip", []byte("not a zip"), nil))
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Files) != 1 || resp.Files[0].Status != UploadFailed || !strings.Contains(resp.Files[0].Error, "Invalid archive") {
+		t.Errorf("Expected the archive to fail, got %+v", resp.Files)
+	}
+}
+
+func TestUnpackArchiveLimits(t *testing.T) {
+	old := maxResumableSize
+	maxResumableSize = 10
+	t.Cleanup(func() { maxResumableSize = old })
+
+	archive := newZip(t, [][2]string{{"a.mp3", "123456"}, {"b.mp3", "123456"}})
+	var names []string
+	err := unpackArchive(bytes.NewReader(archive), int64(len(archive)), func(name string, r io.Reader) {
+		names = append(names, name)
+	})
+	if err == nil {
+		t.Error("Expected the archive to be too large")
+	}
+	if len(names) != 1 {
+		t.Errorf("Expected only the first file to be unpacked, got %v", names)
+	}
+}
+
+func TestQueueUploadFile(t *testing.T) {
+	srv := setupTestDB(t)
+	os.MkdirAll(config.Paths.Uploads, 0755)
+
+	batch := &ImportBatch{ID: "batch", Kind: BatchKindUpload}
+	if err := srv.jobs.saveImportBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	result, job := srv.jobs.queueUploadFile(context.Background(), batch.ID, "Live.flac", strings.NewReader("flac data"), DuplicateExisting)
+	if result.Status != UploadQueued || job == nil || result.JobID != job.ID {
+		t.Fatalf("Expected the file to be queued, got %+v", result)
+	}
+	defer os.Remove(job.Input.Upload)
+
+	// FLAC is kept as received and converted when the job runs
+	if job.Input.Upload == "" || !strings.HasSuffix(job.Input.Upload, ".flac") || job.Input.Filename != "Live.mp3" {
+		t.Errorf("Expected the FLAC file to be converted later, got %+v", job.Input)
+	}
+	if data, err := os.ReadFile(job.Input.Upload); err != nil || string(data) != "flac data" {
+		t.Errorf("Expected the file to be stored, got %q, %v", data, err)
+	}
+
+	stored, err := srv.jobs.getJobByID(job.ID)
+	if err != nil || stored.Status != JobQueued || stored.BatchID != batch.ID {
+		t.Errorf("Expected a queued job in the batch, got %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Subfolders of the inbox. Files move to processing while their job runs,
+// then to archive or failed.
+const (
+	inboxProcessing = "processing"
+	inboxArchive    = "archive"
+	inboxFailed     = "failed"
+)
+
+// Inbox settings, from the watch section of the configuration.
+var (
+	// Directory watched for audio files to import; empty to disable
+	inboxDir string
+	// Scan the inbox periodically instead of relying on file events, e.g.
+	// on network shares where they are not delivered
+	inboxPolling bool
+	// Time between scans when polling
+	inboxPollInterval = 30 * time.Second
+	// How long a file must stay unchanged before it is imported, so that
+	// files still being copied or synced are left alone
+	inboxSettleTime = 5 * time.Second
+)
+
+// loadInboxSettings applies the configured watch folder.
+func loadInboxSettings() {
+	inboxDir = config.Watch.Dir
+	inboxPolling = config.Watch.Polling
+	inboxPollInterval = config.Watch.PollInterval
+	inboxSettleTime = config.Watch.SettleTime
+}
+
+// fileState is what a scan saw of a file, to tell when it stops changing.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+// inboxWatcher imports audio files dropped into a directory. Each file is
+// processed like an upload by jobs; where it ends up tells how that went.
+type inboxWatcher struct {
+	dir    string
+	jobs   *jobRegistry
+	settle time.Duration
+	// Starts the jobs created by a scan
+	start func(queued []*Job)
+
+	seen map[string]fileState
+}
+
+func newInboxWatcher(dir string, jobs *jobRegistry) *inboxWatcher {
+	return &inboxWatcher{
+		dir:    dir,
+		jobs:   jobs,
+		settle: inboxSettleTime,
+		start:  func(queued []*Job) { go jobs.runImportBatch(queued) },
+		seen:   make(map[string]fileState),
+	}
+}
+
+// startInboxWatcher watches inboxDir in the background if it is set,
+// importing the files with jobs.
+func startInboxWatcher(jobs *jobRegistry) {
+	if inboxDir == "" {
+		return
+	}
+
+	w := newInboxWatcher(inboxDir, jobs)
+	for _, sub := range []string{"", inboxProcessing, inboxArchive, inboxFailed} {
+		if err := os.MkdirAll(filepath.Join(inboxDir, sub), 0755); err != nil {
+			log.Printf("Failed to create inbox %s: %v", inboxDir, err)
+			return
+		}
+	}
+	go w.run(context.Background(), inboxPolling, inboxPollInterval)
+}
+
+// run scans the inbox whenever it changes, or every pollInterval if polling
+// or if file events are not available. Scans repeat while files are
+// settling or being processed.
+func (w *inboxWatcher) run(ctx context.Context, polling bool, pollInterval time.Duration) {
+	var events chan fsnotify.Event
+	var errs chan error
+	if !polling {
+		watcher, err := fsnotify.NewWatcher()
+		if err == nil {
+			err = watcher.Add(w.dir)
+		}
+		if err != nil {
+			log.Printf("Cannot watch inbox %s, polling every %v instead: %v", w.dir, pollInterval, err)
+			polling = true
+		} else {
+			defer watcher.Close()
+			events, errs = watcher.Events, watcher.Errors
+		}
+	}
+
+	var poll <-chan time.Time
+	if polling {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	log.Printf("Watching inbox %s", w.dir)
+
+	var again <-chan time.Time
+	for {
+		if w.scan() {
+			again = time.After(w.settle)
+		} else {
+			again = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-events:
+		case err := <-errs:
+			log.Printf("Error watching inbox %s: %v", w.dir, err)
+		case <-poll:
+		case <-again:
+		}
+	}
+}
+
+// scan imports the files in the inbox that have settled and moves the files
+// of finished jobs out of processing. It reports whether anything is still
+// pending, so that the inbox should be scanned again soon.
+func (w *inboxWatcher) scan() bool {
+	pending := w.sortProcessed()
+
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		log.Printf("Failed to read inbox %s: %v", w.dir, err)
+		return pending
+	}
+
+	now := time.Now()
+	present := make(map[string]bool)
+	var queued []*Job
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !audioExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		present[name] = true
+
+		state := fileState{size: info.Size(), modTime: info.ModTime(), since: now}
+		last, ok := w.seen[name]
+		if !ok || last.size != state.size || !last.modTime.Equal(state.modTime) {
+			w.seen[name] = state
+			pending = true
+			continue
+		}
+		if now.Sub(last.since) < w.settle {
+			pending = true
+			continue
+		}
+
+		delete(w.seen, name)
+		if job := w.ingest(name); job != nil {
+			queued = append(queued, job)
+			pending = true
+		}
+	}
+
+	// Forget files that were removed before they settled
+	for name := range w.seen {
+		if !present[name] {
+			delete(w.seen, name)
+		}
+	}
+
+	if len(queued) > 0 {
+		w.start(queued)
+	}
+	return pending
+}
+
+// ingest queues a job for the settled file name and moves it to a
+// processing folder named after the job. Files already in the library are
+// archived right away.
+func (w *inboxWatcher) ingest(name string) *Job {
+	path := filepath.Join(w.dir, name)
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("Failed to open %s: %v", path, err)
+		return nil
+	}
+	result, job := w.jobs.queueUploadFile(context.Background(), "", name, f, DuplicateExisting)
+	f.Close()
+
+	switch result.Status {
+	case UploadDuplicate:
+		log.Printf("Inbox file %s is already in the library as song %s", name, result.SongID)
+		w.moveOut(path, inboxArchive, "")
+		return nil
+	case UploadQueued:
+	default:
+		log.Printf("Failed to import inbox file %s: %s", name, result.Error)
+		w.moveOut(path, inboxFailed, result.Error)
+		return nil
+	}
+
+	dir := filepath.Join(w.dir, inboxProcessing, job.ID)
+	if err := os.MkdirAll(dir, 0755); err == nil {
+		err = os.Rename(path, filepath.Join(dir, name))
+	}
+	if err != nil {
+		// The job runs anyway; only the inbox file stays where it is
+		log.Printf("Failed to move inbox file %s to processing: %v", name, err)
+	}
+	log.Printf("Importing inbox file %s as job %s", name, job.ID)
+	return job
+}
+
+// sortProcessed moves the files of finished jobs from processing to archive
+// or failed. It reports whether any job is still queued or running.
+func (w *inboxWatcher) sortProcessed() bool {
+	processing := filepath.Join(w.dir, inboxProcessing)
+	dirs, err := os.ReadDir(processing)
+	if err != nil {
+		return false
+	}
+
+	pending := false
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		jobDir := filepath.Join(processing, dir.Name())
+
+		job, err := w.jobs.getJobByID(dir.Name())
+		dest, message := "", ""
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Not a job of ours; import its files again
+			dest = ""
+		case err != nil:
+			log.Printf("Failed to fetch job %s: %v", dir.Name(), err)
+			pending = true
+			continue
+		case job.Status == JobCompleted:
+			dest = inboxArchive
+		case job.Status == JobFailed || job.Status == JobCancelled:
+			dest, message = inboxFailed, job.Error
+			if message == "" {
+				message = string(job.Status)
+			}
+		default:
+			pending = true
+			continue
+		}
+
+		files, _ := os.ReadDir(jobDir)
+		for _, f := range files {
+			path := filepath.Join(jobDir, f.Name())
+			if dest == "" {
+				w.moveTo(path, filepath.Join(w.dir, f.Name()))
+			} else {
+				w.moveOut(path, dest, message)
+			}
+		}
+		os.Remove(jobDir)
+	}
+	return pending
+}
+
+// moveOut moves path into the inbox subfolder sub, next to a note with the
+// reason if it failed.
+func (w *inboxWatcher) moveOut(path, sub, message string) {
+	dest := availablePath(filepath.Join(w.dir, sub, filepath.Base(path)))
+	if !w.moveTo(path, dest) || message == "" {
+		return
+	}
+	if err := os.WriteFile(dest+".error.txt", []byte(message+"\n"), 0644); err != nil {
+		log.Printf("Failed to write error for %s: %v", dest, err)
+	}
+}
+
+func (w *inboxWatcher) moveTo(path, dest string) bool {
+	if err := os.Rename(path, dest); err != nil {
+		log.Printf("Failed to move inbox file %s: %v", path, err)
+		return false
+	}
+	return true
+}
+
+// availablePath returns path, or if it exists, the first free variant with a
+// number added to the name.
+func availablePath(path string) string {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	candidate := path
+	for i := 1; ; i++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
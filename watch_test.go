@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestInbox returns a watcher for a new inbox that imports files with
+// jobs on the second scan that sees them unchanged, and collects the jobs
+// it starts.
+func newTestInbox(t *testing.T, jobs *jobRegistry) (*inboxWatcher, *[]*Job) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, sub := range []string{inboxProcessing, inboxArchive, inboxFailed} {
+		os.MkdirAll(filepath.Join(dir, sub), 0755)
+	}
+	os.MkdirAll(config.Paths.Uploads, 0755)
+
+	var started []*Job
+	w := newInboxWatcher(dir, jobs)
+	w.settle = 0
+	w.start = func(queued []*Job) { started = append(started, queued...) }
+	return w, &started
+}
+
+func TestInboxWaitsForFilesToSettle(t *testing.T) {
+	srv := setupTestDB(t)
+	w, started := newTestInbox(t, srv.jobs)
+
+	path := filepath.Join(w.dir, "Rehearsal.flac")
+	os.WriteFile(path, []byte("first half"), 0644)
+	os.WriteFile(filepath.Join(w.dir, ".Rehearsal.flac.part"), []byte("syncing"), 0644)
+	os.WriteFile(filepath.Join(w.dir, "notes.txt"), []byte("setlist"), 0644)
+
+	if !w.scan() || len(*started) != 0 {
+		t.Fatal("Expected a new file to wait for the next scan")
+	}
+
+	// Still being written
+	os.WriteFile(path, []byte("first half, second half"), 0644)
+	if !w.scan() || len(*started) != 0 {
+		t.Fatal("Expected a growing file to wait")
+	}
+
+	if !w.scan() || len(*started) != 1 {
+		t.Fatalf("Expected the settled file to be imported, got %d jobs", len(*started))
+	}
+	job := (*started)[0]
+	defer os.Remove(job.Input.Upload)
+
+	if job.Input.Filename != "Rehearsal.mp3" || !strings.HasSuffix(job.Input.Upload, ".flac") {
+		t.Errorf("Expected the FLAC file to be converted by the job, got %+v", job.Input)
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, inboxProcessing, job.ID, "Rehearsal.flac")); err != nil {
+		t.Errorf("Expected the file to move to processing: %v", err)
+	}
+	for _, name := range []string{".Rehearsal.flac.part", "notes.txt"} {
+		if _, err := os.Stat(filepath.Join(w.dir, name)); err != nil {
+			t.Errorf("Expected %s to be left alone: %v", name, err)
+		}
+	}
+}
+
+func TestInboxSortsFinishedJobs(t *testing.T) {
+	srv := setupTestDB(t)
+	w, started := newTestInbox(t, srv.jobs)
+
+	for _, name := range []string{"Good.mp3", "Bad.mp3"} {
+		os.WriteFile(filepath.Join(w.dir, name), []byte(name), 0644)
+	}
+	w.scan()
+	w.scan()
+	if len(*started) != 2 {
+		t.Fatalf("Expected 2 jobs, got %d", len(*started))
+	}
+	for _, job := range *started {
+		defer os.Remove(job.Input.Original)
+	}
+
+	// Jobs still running keep their files in processing
+	if !w.scan() {
+		t.Error("Expected running jobs to keep the inbox pending")
+	}
+
+	for _, job := range *started {
+		job.Status = JobCompleted
+		if job.Input.Title == "Bad.mp3" {
+			job.Status = JobFailed
+			job.Error = "Separation failed"
+		}
+		if err := srv.jobs.updateJob(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An archived file with the same name is kept
+	os.WriteFile(filepath.Join(w.dir, inboxArchive, "Good.mp3"), []byte("older"), 0644)
+
+	if w.scan() {
+		t.Error("Expected nothing to be pending once the jobs finished")
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, inboxArchive, "Good (1).mp3")); err != nil {
+		t.Errorf("Expected the completed file to be archived: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(w.dir, inboxFailed, "Bad.mp3")); err != nil {
+		t.Errorf("Expected the failed file to be moved: %v", err)
+	}
+	message, err := os.ReadFile(filepath.Join(w.dir, inboxFailed, "Bad.mp3.error.txt"))
+	if err != nil || strings.TrimSpace(string(message)) != "Separation failed" {
+		t.Errorf("Expected the error next to the failed file, got %q, %v", message, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(w.dir, inboxProcessing)); len(entries) != 0 {
+		t.Errorf("Expected processing to be empty, got %d entries", len(entries))
+	}
+}
+
+func TestInboxReimportsUnknownJobs(t *testing.T) {
+	srv := setupTestDB(t)
+	w, _ := newTestInbox(t, srv.jobs)
+
+	dir := filepath.Join(w.dir, inboxProcessing, "lost-job")
+	os.MkdirAll(dir, 0755)
+	os.WriteFile(filepath.Join(dir, "Lost.mp3"), []byte("lost"), 0644)
+
+	w.sortProcessed()
+	if _, err := os.Stat(filepath.Join(w.dir, "Lost.mp3")); err != nil {
+		t.Errorf("Expected the file to return to the inbox: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Error("Expected the job folder to be removed")
+	}
+}
+
+func TestInboxWatch(t *testing.T) {
+	for _, polling := range []bool{false, true} {
+		srv := setupTestDB(t)
+		w, _ := newTestInbox(t, srv.jobs)
+		w.settle = 10 * time.Millisecond
+		saveHashedSong(t, srv.songs, "existing", []byte("known song"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			w.run(ctx, polling, 20*time.Millisecond)
+			close(done)
+		}()
+
+		// Give the watcher time to start before the file arrives
+		time.Sleep(50 * time.Millisecond)
+		os.WriteFile(filepath.Join(w.dir, "Known.mp3"), []byte("known song"), 0644)
+
+		archived := filepath.Join(w.dir, inboxArchive, "Known.mp3")
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(archived); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancel()
+		<-done
+		if _, err := os.Stat(archived); err != nil {
+			t.Errorf("polling=%v: Expected the known song to be archived: %v", polling, err)
+		}
+		srv.db.Close()
+	}
+}
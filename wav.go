@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	wavFormatPCM        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavReader streams the samples of a 16-bit PCM or 32-bit float WAV file,
+// the formats Spleeter and FFmpeg write, as interleaved float32 frames.
+type wavReader struct {
+	f        *os.File
+	r        *bufio.Reader
+	format   int
+	Channels int
+	Rate     int
+	Frames   int64
+	read     int64
+}
+
+func openWav(path string) (*wavReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wavReader{f: f, r: bufio.NewReaderSize(f, 64*1024)}
+	if err := w.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return w, nil
+}
+
+func (w *wavReader) readHeader() error {
+	var riff [12]byte
+	if _, err := io.ReadFull(w.r, riff[:]); err != nil {
+		return err
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return errors.New("not a WAV file")
+	}
+
+	bitsPerSample := 0
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(w.r, chunk[:]); err != nil {
+			return fmt.Errorf("no data chunk: %w", err)
+		}
+		id := string(chunk[0:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(w.r, buf); err != nil {
+				return err
+			}
+			if size < 16 {
+				return errors.New("short fmt chunk")
+			}
+			w.format = int(binary.LittleEndian.Uint16(buf[0:2]))
+			w.Channels = int(binary.LittleEndian.Uint16(buf[2:4]))
+			w.Rate = int(binary.LittleEndian.Uint32(buf[4:8]))
+			bitsPerSample = int(binary.LittleEndian.Uint16(buf[14:16]))
+			if w.format == wavFormatExtensible && size >= 26 {
+				w.format = int(binary.LittleEndian.Uint16(buf[24:26]))
+			}
+		case "data":
+			switch {
+			case w.format == wavFormatPCM && bitsPerSample == 16:
+			case w.format == wavFormatFloat && bitsPerSample == 32:
+			default:
+				return fmt.Errorf("unsupported sample format %d with %d bits", w.format, bitsPerSample)
+			}
+			if w.Channels < 1 {
+				return errors.New("no channels")
+			}
+			w.Frames = size / int64(w.Channels*bitsPerSample/8)
+			return nil
+		default:
+			if _, err := w.r.Discard(int(size + size%2)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Read fills buf with interleaved samples, returning the number of whole
+// frames read. It returns io.EOF once every frame has been read.
+func (w *wavReader) Read(buf []float32) (int, error) {
+	frames := int64(len(buf) / w.Channels)
+	if remaining := w.Frames - w.read; frames > remaining {
+		frames = remaining
+	}
+	if frames == 0 {
+		return 0, io.EOF
+	}
+
+	samples := buf[:frames*int64(w.Channels)]
+	var err error
+	if w.format == wavFormatPCM {
+		raw := make([]int16, len(samples))
+		err = binary.Read(w.r, binary.LittleEndian, raw)
+		for i, s := range raw {
+			samples[i] = float32(s) / 32768
+		}
+	} else {
+		err = binary.Read(w.r, binary.LittleEndian, samples)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	w.read += frames
+	return int(frames), nil
+}
+
+// ReadFrames reads exactly n frames, or fewer if the file ends first.
+func (w *wavReader) ReadFrames(n int64) ([]float32, error) {
+	buf := make([]float32, n*int64(w.Channels))
+	total := 0
+	for total < len(buf) {
+		frames, err := w.Read(buf[total:])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		total += frames * w.Channels
+	}
+	return buf[:total], nil
+}
+
+func (w *wavReader) Close() error {
+	return w.f.Close()
+}
+
+// wavWriter writes interleaved float32 frames as a 32-bit float WAV file.
+type wavWriter struct {
+	f        *os.File
+	w        *bufio.Writer
+	Channels int
+	Rate     int
+	frames   int64
+}
+
+func createWav(path string, channels, rate int) (*wavWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &wavWriter{f: f, w: bufio.NewWriterSize(f, 64*1024), Channels: channels, Rate: rate}
+	// The sizes are filled in by Close
+	if err := w.writeHeader(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wavWriter) writeHeader() error {
+	dataSize := uint32(w.frames * int64(w.Channels) * 4)
+	header := struct {
+		Riff          [4]byte
+		RiffSize      uint32
+		Wave          [4]byte
+		Fmt           [4]byte
+		FmtSize       uint32
+		Format        uint16
+		Channels      uint16
+		Rate          uint32
+		ByteRate      uint32
+		BlockAlign    uint16
+		BitsPerSample uint16
+		Data          [4]byte
+		DataSize      uint32
+	}{
+		Riff:          [4]byte{'R', 'I', 'F', 'F'},
+		RiffSize:      36 + dataSize,
+		Wave:          [4]byte{'W', 'A', 'V', 'E'},
+		Fmt:           [4]byte{'f', 'm', 't', ' '},
+		FmtSize:       16,
+		Format:        wavFormatFloat,
+		Channels:      uint16(w.Channels),
+		Rate:          uint32(w.Rate),
+		ByteRate:      uint32(w.Rate * w.Channels * 4),
+		BlockAlign:    uint16(w.Channels * 4),
+		BitsPerSample: 32,
+		Data:          [4]byte{'d', 'a', 't', 'a'},
+		DataSize:      dataSize,
+	}
+	return binary.Write(w.w, binary.LittleEndian, header)
+}
+
+// Write appends interleaved samples; len(samples) must be a whole number of
+// frames.
+func (w *wavWriter) Write(samples []float32) error {
+	if len(samples)%w.Channels != 0 {
+		return fmt.Errorf("partial frame of %d samples", len(samples))
+	}
+	if err := binary.Write(w.w, binary.LittleEndian, samples); err != nil {
+		return err
+	}
+	w.frames += int64(len(samples) / w.Channels)
+	return nil
+}
+
+// Close flushes the samples and fills in the header sizes.
+func (w *wavWriter) Close() error {
+	if w.frames*int64(w.Channels)*4 > math.MaxUint32-36 {
+		w.f.Close()
+		return errors.New("WAV file too large")
+	}
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		w.f.Close()
+		return err
+	}
+	w.w.Reset(w.f)
+	if err := w.writeHeader(); err != nil {
+		w.f.Close()
+		return err
+	}
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWavRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+
+	w, err := createWav(path, 2, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := []float32{0, 0.5, -0.5, 1, 0.25, -1}
+	if err := w.Write(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]float32{0.1}); err == nil {
+		t.Error("Expected an error for a partial frame")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openWav(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.Channels != 2 || r.Rate != 44100 || r.Frames != 3 {
+		t.Fatalf("Expected 3 stereo frames at 44100 Hz, got %d frames, %d channels at %d Hz", r.Frames, r.Channels, r.Rate)
+	}
+	got, err := r.ReadFrames(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(samples) {
+		t.Fatalf("Expected %d samples, got %d", len(samples), len(got))
+	}
+	for i := range samples {
+		if got[i] != samples[i] {
+			t.Errorf("Sample %d: expected %v, got %v", i, samples[i], got[i])
+		}
+	}
+}
+
+func TestReadPCM16Wav(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pcm.wav")
+
+	data := []int16{0, 16384, -32768, 32767}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	f.Write([]byte("RIFF"))
+	binary.Write(f, le, uint32(4+8+16+8+12+8+len(data)*2))
+	f.Write([]byte("WAVE"))
+	f.Write([]byte("fmt "))
+	binary.Write(f, le, []uint32{16})
+	binary.Write(f, le, []uint16{wavFormatPCM, 1})
+	binary.Write(f, le, []uint32{8000, 16000})
+	binary.Write(f, le, []uint16{2, 16})
+	// Chunks other than fmt and data are skipped
+	f.Write([]byte("LIST"))
+	binary.Write(f, le, uint32(12))
+	f.Write(make([]byte, 12))
+	f.Write([]byte("data"))
+	binary.Write(f, le, uint32(len(data)*2))
+	binary.Write(f, le, data)
+	f.Close()
+
+	r, err := openWav(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if r.Channels != 1 || r.Rate != 8000 || r.Frames != 4 {
+		t.Fatalf("Expected 4 mono frames at 8000 Hz, got %d frames, %d channels at %d Hz", r.Frames, r.Channels, r.Rate)
+	}
+	got, err := r.ReadFrames(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []float32{0, 0.5, -1, 32767.0 / 32768}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Sample %d: expected %v, got %v", i, expected[i], got[i])
+		}
+	}
+}
+
+func TestOpenWavRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	os.WriteFile(path, []byte("ID3 not a wav file"), 0644)
+
+	if _, err := openWav(path); err == nil {
+		t.Error("Expected an error for a non-WAV file")
+	}
+}
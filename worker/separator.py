@@ -0,0 +1,74 @@
+#!/usr/bin/env python3
+"""Long-lived Spleeter worker for Drummer.
+
+Loads the separation model once and then serves requests read as JSON lines
+from stdin, answering each with a JSON line on stdout:
+
+    {"id": "1", "cmd": "ping"}
+    {"id": "2", "cmd": "separate", "inputs": ["a.mp3"], "output": "dir"}
+
+    {"id": "2", "ok": true}
+    {"id": "2", "ok": false, "error": "..."}
+
+Stems are written to <output>/<input basename>/<stem>.wav, the same layout
+as `spleeter separate`. A {"event": "ready"} line is sent once the model is
+loaded and warmed up.
+"""
+
+import json
+import os
+import sys
+import traceback
+
+
+def main():
+    model = sys.argv[1] if len(sys.argv) > 1 else "spleeter:5stems-16kHz"
+
+    # Keep the protocol stream to ourselves: anything else written to stdout,
+    # including by TensorFlow's native code, goes to stderr instead.
+    protocol = os.fdopen(os.dup(1), "w")
+    os.dup2(2, 1)
+
+    def respond(message):
+        protocol.write(json.dumps(message) + "\n")
+        protocol.flush()
+
+    import numpy as np
+    from spleeter.separator import Separator
+
+    separator = Separator(model, multiprocess=False)
+
+    # Run a second of silence through the model so that the graph is built
+    # before the first real song arrives.
+    separator.separate(np.zeros((44100, 2), dtype=np.float32))
+    respond({"event": "ready"})
+
+    for line in sys.stdin:
+        line = line.strip()
+        if not line:
+            continue
+
+        try:
+            request = json.loads(line)
+        except ValueError as e:
+            respond({"ok": False, "error": "invalid request: %s" % e})
+            continue
+
+        request_id = request.get("id")
+        command = request.get("cmd")
+        try:
+            if command == "ping":
+                respond({"id": request_id, "ok": True})
+            elif command == "separate":
+                for path in request["inputs"]:
+                    separator.separate_to_file(path, request["output"], synchronous=True)
+                respond({"id": request_id, "ok": True})
+            else:
+                respond({"id": request_id, "ok": False, "error": "unknown command %r" % command})
+        except Exception as e:
+            traceback.print_exc(file=sys.stderr)
+            respond({"id": request_id, "ok": False, "error": "%s: %s" % (type(e).__name__, e)})
+
+
+if __name__ == "__main__":
+    main()
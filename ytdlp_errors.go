@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// YtdlpErrorCode classifies why yt-dlp could not download a video. The
+// values are returned to API clients as the "code" of the error.
+type YtdlpErrorCode string
+
+const (
+	YtdlpUnavailable    YtdlpErrorCode = "video_unavailable"
+	YtdlpPrivate        YtdlpErrorCode = "video_private"
+	YtdlpGeoBlocked     YtdlpErrorCode = "geo_blocked"
+	YtdlpAgeRestricted  YtdlpErrorCode = "age_restricted"
+	YtdlpMembersOnly    YtdlpErrorCode = "members_only"
+	YtdlpRateLimited    YtdlpErrorCode = "rate_limited"
+	YtdlpUnsupportedURL YtdlpErrorCode = "unsupported_url"
+	YtdlpNetwork        YtdlpErrorCode = "network_error"
+	YtdlpUnknown        YtdlpErrorCode = "download_failed"
+)
+
+// ytdlpErrorInfo is how each kind of failure is reported to clients.
+var ytdlpErrorInfo = map[YtdlpErrorCode]struct {
+	Status  int
+	Message string
+}{
+	YtdlpUnavailable:    {http.StatusNotFound, "Video not found or no longer available"},
+	YtdlpPrivate:        {http.StatusForbidden, "This video is private"},
+	YtdlpGeoBlocked:     {http.StatusUnavailableForLegalReasons, "This video is not available in the server's country"},
+	YtdlpAgeRestricted:  {http.StatusForbidden, "Video is age-restricted or requires login"},
+	YtdlpMembersOnly:    {http.StatusForbidden, "This video is only available to channel members"},
+	YtdlpRateLimited:    {http.StatusTooManyRequests, "YouTube is limiting requests, please try again later"},
+	YtdlpUnsupportedURL: {http.StatusBadRequest, "This URL is not supported"},
+	YtdlpNetwork:        {http.StatusBadGateway, "Network error: Unable to connect to YouTube"},
+	YtdlpUnknown:        {http.StatusBadGateway, "Failed to download from YouTube"},
+}
+
+// Patterns in yt-dlp's ERROR lines, checked in order so that the more
+// specific ones win: a private video is also reported as unavailable.
+var ytdlpErrorPatterns = []struct {
+	Code     YtdlpErrorCode
+	Patterns []string
+}{
+	{YtdlpUnsupportedURL, []string{"Unsupported URL", "is not a valid URL"}},
+	{YtdlpPrivate, []string{"Private video", "This video is private"}},
+	{YtdlpMembersOnly, []string{"members-only content", "Join this channel to get access", "available to this channel's members"}},
+	{YtdlpAgeRestricted, []string{"Sign in to confirm your age", "age-restricted", "inappropriate for some users"}},
+	{YtdlpGeoBlocked, []string{"not available in your country", "not made this video available in your country", "blocked it in your country", "geo restriction", "geo-restricted"}},
+	{YtdlpRateLimited, []string{"HTTP Error 429", "Too Many Requests", "confirm you're not a bot", "confirm you’re not a bot", "rate-limited"}},
+	{YtdlpUnavailable, []string{"Video unavailable", "This video is not available", "has been removed", "does not exist", "HTTP Error 404", "This video has been terminated"}},
+	{YtdlpNetwork, []string{"Unable to download webpage", "Unable to download API page", "urlopen error", "Connection reset", "Connection refused", "Network is unreachable", "Temporary failure in name resolution", "timed out", "Failed to resolve"}},
+}
+
+// ytdlpError is a download failure classified from yt-dlp's output.
+type ytdlpError struct {
+	Code YtdlpErrorCode
+	// The ERROR line yt-dlp printed, if any
+	Line string
+	Err  error
+}
+
+func (e *ytdlpError) Error() string {
+	if e.Line != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Line)
+	}
+	return fmt.Sprintf("%s: %v", e.Code, e.Err)
+}
+
+func (e *ytdlpError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ytdlpError) Status() int {
+	return ytdlpErrorInfo[e.Code].Status
+}
+
+func (e *ytdlpError) Message() string {
+	return ytdlpErrorInfo[e.Code].Message
+}
+
+// Permanent reports whether downloading again cannot help.
+func (e *ytdlpError) Permanent() bool {
+	switch e.Code {
+	case YtdlpUnavailable, YtdlpPrivate, YtdlpGeoBlocked, YtdlpAgeRestricted, YtdlpMembersOnly, YtdlpUnsupportedURL:
+		return true
+	}
+	return false
+}
+
+// NeedsLogin reports whether the video could be downloaded with the cookies
+// of a YouTube account that has access to it.
+func (e *ytdlpError) NeedsLogin() bool {
+	switch e.Code {
+	case YtdlpPrivate, YtdlpAgeRestricted, YtdlpMembersOnly:
+		return true
+	}
+	return false
+}
+
+// parseYtdlpOutput classifies the ERROR lines in yt-dlp's stderr. The last
+// line that matches a known pattern wins, since yt-dlp reports the final
+// failure last. It returns nil if there are no ERROR lines.
+func parseYtdlpOutput(output string) *ytdlpError {
+	var found *ytdlpError
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "ERROR:") {
+			continue
+		}
+
+		code := classifyYtdlpLine(line)
+		if found == nil || code != YtdlpUnknown || found.Code == YtdlpUnknown {
+			found = &ytdlpError{Code: code, Line: line}
+		}
+	}
+	return found
+}
+
+func classifyYtdlpLine(line string) YtdlpErrorCode {
+	lower := strings.ToLower(line)
+	for _, group := range ytdlpErrorPatterns {
+		for _, pattern := range group.Patterns {
+			if strings.Contains(lower, strings.ToLower(pattern)) {
+				return group.Code
+			}
+		}
+	}
+	return YtdlpUnknown
+}
+
+// classifyDownloadError turns a failed yt-dlp run into a ytdlpError wrapping
+// err. Timeouts and cancellations are returned unchanged.
+func classifyDownloadError(err error) error {
+	var serr *stageError
+	if !errors.As(err, &serr) || serr.TimedOut || errors.Is(err, context.Canceled) {
+		return err
+	}
+
+	yerr := parseYtdlpOutput(serr.Output)
+	if yerr == nil {
+		yerr = &ytdlpError{Code: YtdlpUnknown}
+	}
+	yerr.Err = err
+	return yerr
+}
+
+// downloadJobError reports a failed download with the message for its kind.
+func downloadJobError(err error) *jobError {
+	err = classifyDownloadError(err)
+
+	message := ytdlpErrorInfo[YtdlpUnknown].Message
+	var yerr *ytdlpError
+	if errors.As(err, &yerr) {
+		message = yerr.Message()
+	}
+	return &jobError{message, err}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseYtdlpOutput(t *testing.T) {
+	tests := []struct {
+		sample    string
+		code      YtdlpErrorCode
+		status    int
+		permanent bool
+	}{
+		{"unavailable.txt", YtdlpUnavailable, http.StatusNotFound, true},
+		{"private.txt", YtdlpPrivate, http.StatusForbidden, true},
+		{"geo_blocked.txt", YtdlpGeoBlocked, http.StatusUnavailableForLegalReasons, true},
+		{"geo_copyright.txt", YtdlpGeoBlocked, http.StatusUnavailableForLegalReasons, true},
+		{"age_restricted.txt", YtdlpAgeRestricted, http.StatusForbidden, true},
+		{"members_only.txt", YtdlpMembersOnly, http.StatusForbidden, true},
+		{"rate_limited.txt", YtdlpRateLimited, http.StatusTooManyRequests, false},
+		{"bot_check.txt", YtdlpRateLimited, http.StatusTooManyRequests, false},
+		{"unsupported_url.txt", YtdlpUnsupportedURL, http.StatusBadRequest, true},
+		{"invalid_url.txt", YtdlpUnsupportedURL, http.StatusBadRequest, true},
+		{"network.txt", YtdlpNetwork, http.StatusBadGateway, false},
+		{"connection_reset.txt", YtdlpNetwork, http.StatusBadGateway, false},
+		{"unknown.txt", YtdlpUnknown, http.StatusBadGateway, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.sample, func(t *testing.T) {
+			output, err := os.ReadFile(filepath.Join("testdata", "ytdlp", test.sample))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			yerr := parseYtdlpOutput(string(output))
+			if yerr == nil {
+				t.Fatal("Expected an error to be found")
+			}
+			if yerr.Code != test.code {
+				t.Errorf("Expected code %s, got %s (from %q)", test.code, yerr.Code, yerr.Line)
+			}
+			if yerr.Status() != test.status {
+				t.Errorf("Expected status %d, got %d", test.status, yerr.Status())
+			}
+			if yerr.Permanent() != test.permanent {
+				t.Errorf("Expected permanent to be %v", test.permanent)
+			}
+
+			// Permanent failures are not retried
+			serr := &stageError{Stage: StageDownloading, Output: string(output), Err: errors.New("exit status 1")}
+			if isRetryable(serr) == test.permanent {
+				t.Errorf("Expected isRetryable to be %v", !test.permanent)
+			}
+		})
+	}
+}
+
+func TestParseYtdlpOutputWithoutErrors(t *testing.T) {
+	if yerr := parseYtdlpOutput("[youtube] abc: Downloading webpage\nWARNING: slow\n"); yerr != nil {
+		t.Errorf("Expected no error, got %v", yerr)
+	}
+}
+
+func TestClassifyDownloadError(t *testing.T) {
+	// Timeouts and cancellations keep their own handling
+	timeout := &stageError{Stage: StageDownloading, TimedOut: true, Output: "ERROR: Video unavailable"}
+	if err := classifyDownloadError(timeout); err != timeout {
+		t.Errorf("Expected a timeout to be returned unchanged, got %v", err)
+	}
+	cancelled := &stageError{Stage: StageDownloading, Err: context.Canceled}
+	if err := classifyDownloadError(cancelled); err != cancelled {
+		t.Errorf("Expected a cancellation to be returned unchanged, got %v", err)
+	}
+
+	// Failures without an ERROR line are still typed
+	var yerr *ytdlpError
+	err := classifyDownloadError(&stageError{Stage: StageDownloading, Err: errors.New("exit status 1")})
+	if !errors.As(err, &yerr) || yerr.Code != YtdlpUnknown {
+		t.Errorf("Expected an unknown download error, got %v", err)
+	}
+}
+
+func TestRespondProcessingErrorCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	private := &stageError{Stage: StageDownloading, Output: "ERROR: [youtube] abc: Private video. Sign in if you've been granted access to this video", Err: errors.New("exit status 1")}
+	tests := []struct {
+		err    error
+		status int
+		body   string
+	}{
+		{downloadJobError(private), http.StatusForbidden, `{"code":"video_private","error":"This video is private"}`},
+		{context.Canceled, http.StatusConflict, `{"code":"cancelled","error":"Processing was cancelled"}`},
+		{&jobError{"Failed to process audio", errors.New("spleeter crashed")}, http.StatusInternalServerError, `{"code":"processing_failed","error":"Failed to process audio"}`},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		respondProcessingError(c, test.err)
+
+		if w.Code != test.status {
+			t.Errorf("%v: expected status code %d, got %d", test.err, test.status, w.Code)
+		}
+		if w.Body.String() != test.body {
+			t.Errorf("%v: expected body %s, got %s", test.err, test.body, w.Body.String())
+		}
+	}
+}